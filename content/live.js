@@ -0,0 +1,38 @@
+// Live thread updates: new comments and scores are pushed by the server
+// over Server-Sent Events and inserted into the page.
+const thread = document.querySelector("[data-live]");
+if (thread && window.EventSource) {
+  const source = new EventSource(thread.dataset.live);
+
+  source.addEventListener("comment", (event) => {
+    const comment = JSON.parse(event.data);
+    if (document.getElementById(comment.anchor)) {
+      return;
+    }
+
+    let container = thread;
+    let indentation = 0;
+    const parent = comment.parent_anchor && document.getElementById(comment.parent_anchor);
+    if (parent) {
+      // Replies follow their comment, see comment.template
+      container = parent.nextElementSibling;
+      indentation = (parseInt(parent.style.marginLeft, 10) || 0) + 20;
+    }
+
+    const template = document.createElement("template");
+    template.innerHTML = comment.html;
+    template.content.querySelector(".comment").style.marginLeft = indentation + "px";
+    container.append(template.content);
+  });
+
+  source.addEventListener("score", (event) => {
+    const update = JSON.parse(event.data);
+    const box = update.anchor
+      ? document.getElementById(update.anchor)
+      : document.querySelector(".post-votes");
+    const score = box?.querySelector(".score");
+    if (score) {
+      score.textContent = update.score;
+    }
+  });
+}
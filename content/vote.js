@@ -0,0 +1,39 @@
+// Progressive enhancement for the vote forms: votes are sent to /vote
+// and the score is updated in place. Without JavaScript the forms post
+// to the page and redirect back.
+document.addEventListener("submit", async (event) => {
+  const form = event.target;
+  const box = form.closest("[data-votes]");
+  if (!box || form.elements["type"]?.value !== "vote") {
+    return;
+  }
+  event.preventDefault();
+
+  let response;
+  try {
+    response = await fetch("/vote", {
+      method: "POST",
+      headers: { "Accept": "application/json" },
+      body: new URLSearchParams(new FormData(form)),
+    });
+  } catch {
+    form.submit();
+    return;
+  }
+
+  if (response.status === 401) {
+    window.location.href = "/login";
+    return;
+  }
+  if (!response.ok) {
+    form.submit();
+    return;
+  }
+
+  const result = await response.json();
+  box.querySelector(".score").textContent = result.score;
+  for (const button of box.querySelectorAll("form")) {
+    const vote = button.elements["vote_type"].value;
+    button.querySelector("button").classList.toggle("voted", vote === result.vote);
+  }
+});
@@ -0,0 +1,657 @@
+// Package activitypub federates the forum with other ActivityPub servers.
+//
+// Every local user is an actor at /u/<user>, found with WebFinger as
+// <user>@<host of the base URL>. Posts are Articles, comments are Notes,
+// both at the URL of their page. Accounts of other servers become remote
+// users of the storage: their replies to local posts and comments are
+// stored as comments, and the posts of accounts followed by local users
+// as posts. Requests between servers are signed with HTTP signatures.
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"forumapp/events"
+	"forumapp/markup"
+	"forumapp/storage"
+	"forumapp/tmpl"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ContentType = "application/activity+json"
+	Public      = "https://www.w3.org/ns/activitystreams#Public"
+
+	maxBodySize    = 1 << 20
+	requestTimeout = 10 * time.Second
+	// Remote actors are fetched again after
+	actorCacheTTL = time.Hour
+	// Newest posts listed in an outbox
+	outboxSize = 20
+)
+
+var activityContext = []string{
+	"https://www.w3.org/ns/activitystreams",
+	"https://w3id.org/security/v1",
+}
+
+// IRIs is a list of IDs, a single ID is accepted when decoding
+type IRIs []string
+
+func (iris *IRIs) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*iris = IRIs{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*iris = list
+	return nil
+}
+
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+type Image struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType"`
+	URL       string `json:"url"`
+}
+
+type Actor struct {
+	Context           any       `json:"@context,omitempty"`
+	ID                string    `json:"id"`
+	Type              string    `json:"type"`
+	PreferredUsername string    `json:"preferredUsername"`
+	Name              string    `json:"name,omitempty"`
+	Summary           string    `json:"summary,omitempty"`
+	URL               string    `json:"url,omitempty"`
+	Icon              *Image    `json:"icon,omitempty"`
+	Inbox             string    `json:"inbox"`
+	Outbox            string    `json:"outbox,omitempty"`
+	Followers         string    `json:"followers,omitempty"`
+	Published         string    `json:"published,omitempty"`
+	PublicKey         PublicKey `json:"publicKey"`
+}
+
+type Source struct {
+	Content   string `json:"content"`
+	MediaType string `json:"mediaType"`
+}
+
+// Object is a post (Article) or a comment (Note)
+type Object struct {
+	Context      any     `json:"@context,omitempty"`
+	ID           string  `json:"id"`
+	Type         string  `json:"type"`
+	AttributedTo string  `json:"attributedTo,omitempty"`
+	InReplyTo    string  `json:"inReplyTo,omitempty"`
+	Name         string  `json:"name,omitempty"`
+	Content      string  `json:"content,omitempty"`
+	MediaType    string  `json:"mediaType,omitempty"`
+	Source       *Source `json:"source,omitempty"`
+	URL          string  `json:"url,omitempty"`
+	Published    string  `json:"published,omitempty"`
+	To           IRIs    `json:"to,omitempty"`
+	Cc           IRIs    `json:"cc,omitempty"`
+}
+
+type Activity struct {
+	Context any    `json:"@context,omitempty"`
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Actor   string `json:"actor"`
+	// An embedded object or its ID
+	Object    json.RawMessage `json:"object"`
+	Published string          `json:"published,omitempty"`
+	To        IRIs            `json:"to,omitempty"`
+	Cc        IRIs            `json:"cc,omitempty"`
+}
+
+type Collection struct {
+	Context      any        `json:"@context,omitempty"`
+	ID           string     `json:"id"`
+	Type         string     `json:"type"`
+	TotalItems   int        `json:"totalItems"`
+	OrderedItems []Activity `json:"orderedItems,omitempty"`
+}
+
+// rawObject embeds v as the object of an activity
+func rawObject(v any) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// rawID returns the ID of an embedded object or the ID itself
+func rawID(object json.RawMessage) string {
+	var id string
+	if err := json.Unmarshal(object, &id); err == nil {
+		return id
+	}
+	var embedded struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(object, &embedded)
+	return embedded.ID
+}
+
+type cachedActor struct {
+	actor   Actor
+	fetched time.Time
+	// Fetched again before its time, because of a key that didn't match
+	refreshed bool
+}
+
+type Federation struct {
+	strg    *storage.Storage
+	baseURL string
+	// Domain of the handles of local users
+	host string
+	// Other servers are reached by handle over the scheme of the base URL,
+	// so a forum on plain http can federate with another local instance
+	scheme string
+	client *http.Client
+
+	deliveries chan delivery
+	// Limits concurrent deliveries
+	slots chan struct{}
+
+	mu     sync.Mutex
+	actors map[string]cachedActor
+	// Incoming posts and comments are stored one at a time,
+	// so a delivery that is retried isn't stored twice
+	createMu sync.Mutex
+}
+
+func New(strg *storage.Storage, bus *events.Bus, baseURL string) *Federation {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	f := &Federation{
+		strg:       strg,
+		baseURL:    baseURL,
+		host:       "localhost",
+		scheme:     "https",
+		client:     &http.Client{Timeout: requestTimeout},
+		deliveries: make(chan delivery, queueSize),
+		slots:      make(chan struct{}, maxInFlight),
+		actors:     map[string]cachedActor{},
+	}
+	if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+		f.host = u.Host
+		f.scheme = u.Scheme
+	}
+	events.SubscribeAsync(bus, f.postCreated)
+	events.SubscribeAsync(bus, f.commentLinked)
+	events.SubscribeAsync(bus, f.remoteFollow)
+	events.SubscribeAsync(bus, f.remoteUnfollow)
+	return f
+}
+
+func (f *Federation) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /.well-known/webfinger", f.webfinger)
+	mux.HandleFunc("POST /ap/u/{user}/inbox", f.inbox)
+	mux.HandleFunc("GET /ap/u/{user}/outbox", f.outbox)
+	mux.HandleFunc("GET /ap/u/{user}/followers", f.followers)
+}
+
+// Negotiate serves the ActivityPub objects of /u/ pages to clients
+// asking for them and the pages to everyone else
+func (f *Federation) Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		if !wantsActivity(r.Header.Get("Accept")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		f.serveObject(w, r)
+	})
+}
+
+func wantsActivity(accept string) bool {
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if mediaType == ContentType ||
+			(mediaType == "application/ld+json" && strings.Contains(params["profile"], "activitystreams")) {
+			return true
+		}
+	}
+	return false
+}
+
+// actorID returns the ID of a local user, the URL of their page
+func (f *Federation) actorID(username string) string {
+	return f.baseURL + "/u/" + url.PathEscape(username)
+}
+
+func (f *Federation) endpoint(username, name string) string {
+	return f.baseURL + "/ap/u/" + url.PathEscape(username) + "/" + name
+}
+
+// actorOf returns the actor ID of a local or remote user
+func (f *Federation) actorOf(username string) string {
+	if actorID, _, ok := f.strg.RemoteUser(username); ok {
+		return actorID
+	}
+	return f.actorID(username)
+}
+
+// pageURL returns the URL of the page of a post or comment
+func (f *Federation) pageURL(location string) string {
+	username, resource, _ := strings.Cut(strings.TrimPrefix(location, "/"), "/")
+	return f.actorID(username) + "/" + resource
+}
+
+// objectID returns the ID of a post or comment,
+// content of other servers keeps its own ID
+func (f *Federation) objectID(location string) string {
+	if id, ok := f.strg.ObjectID(location); ok {
+		return id
+	}
+	return f.pageURL(location)
+}
+
+// location maps the ID of an actor, a post or a comment
+// to its storage location
+func (f *Federation) location(id string) (string, bool) {
+	if location, ok := f.strg.ObjectLocation(id); ok {
+		return location, true
+	}
+	rest, ok := strings.CutPrefix(id, f.baseURL+"/u/")
+	if !ok {
+		return "", false
+	}
+	rest, _, _ = strings.Cut(rest, "#")
+	escapedUser, resource, _ := strings.Cut(rest, "/")
+	username, err := url.PathUnescape(escapedUser)
+	if err != nil || username == "" {
+		return "", false
+	}
+	if resource == "" {
+		return "/" + username, true
+	}
+	return "/" + username + "/" + resource, true
+}
+
+// localUser reports whether username is a user of this server
+func (f *Federation) localUser(username string) bool {
+	if _, err := f.strg.GetProfile(username); err != nil {
+		return false
+	}
+	return !f.strg.IsRemoteUser(username)
+}
+
+func published(creationDate string) string {
+	t := storage.ParseCreationDate(creationDate)
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// render turns Markdown into HTML for other servers, with absolute links
+func (f *Federation) render(text string) string {
+	html := string(markup.Render(text))
+	html = strings.ReplaceAll(html, `href="/`, `href="`+f.baseURL+`/`)
+	return strings.ReplaceAll(html, `src="/`, `src="`+f.baseURL+`/`)
+}
+
+func (f *Federation) actor(username string) (Actor, error) {
+	profile, err := f.strg.GetProfile(username)
+	if err != nil {
+		return Actor{}, err
+	}
+	key, err := f.strg.ActorKey(username)
+	if err != nil {
+		return Actor{}, err
+	}
+	keyPEM, err := publicKeyPEM(&key.PublicKey)
+	if err != nil {
+		return Actor{}, err
+	}
+
+	id := f.actorID(username)
+	actor := Actor{
+		Context:           activityContext,
+		ID:                id,
+		Type:              "Person",
+		PreferredUsername: username,
+		Name:              profile.DisplayName,
+		URL:               id,
+		Inbox:             f.endpoint(username, "inbox"),
+		Outbox:            f.endpoint(username, "outbox"),
+		Followers:         f.endpoint(username, "followers"),
+		Published:         published(profile.JoinDate),
+		PublicKey: PublicKey{
+			ID:           id + "#main-key",
+			Owner:        id,
+			PublicKeyPem: keyPEM,
+		},
+	}
+	if actor.Name == "" {
+		actor.Name = username
+	}
+	if profile.Bio != "" {
+		actor.Summary = f.render(profile.Bio)
+	}
+	if profile.HasAvatar {
+		actor.Icon = &Image{
+			Type:      "Image",
+			MediaType: "image/png",
+			URL:       f.baseURL + "/avatar/" + url.PathEscape(username),
+		}
+	}
+	return actor, nil
+}
+
+func (f *Federation) article(location string) (Object, error) {
+	post, err := f.strg.GetPost(location)
+	if err != nil {
+		return Object{}, err
+	}
+	id := f.objectID(location)
+	return Object{
+		ID:           id,
+		Type:         "Article",
+		AttributedTo: f.actorOf(post.Author),
+		Name:         post.Title,
+		Content:      f.render(post.Text),
+		MediaType:    "text/html",
+		Source:       &Source{Content: post.Text, MediaType: "text/markdown"},
+		URL:          f.pageURL(location),
+		Published:    published(post.CreationDate),
+		To:           IRIs{Public},
+		Cc:           IRIs{f.endpoint(post.Author, "followers")},
+	}, nil
+}
+
+func (f *Federation) note(location string) (Object, error) {
+	comment, err := f.strg.GetComment(location)
+	if err != nil {
+		return Object{}, err
+	}
+	post, err := f.strg.RootPost(location)
+	if err != nil {
+		return Object{}, err
+	}
+	parentAuthor, _, _ := strings.Cut(strings.TrimPrefix(comment.Location, "/"), "/")
+
+	cc := IRIs{f.endpoint(comment.Author, "followers")}
+	if parentAuthor != comment.Author {
+		cc = append(cc, f.actorOf(parentAuthor))
+	}
+	return Object{
+		ID:           f.objectID(location),
+		Type:         "Note",
+		AttributedTo: f.actorOf(comment.Author),
+		InReplyTo:    f.objectID(comment.Location),
+		Content:      f.render(comment.Text),
+		MediaType:    "text/html",
+		Source:       &Source{Content: comment.Text, MediaType: "text/markdown"},
+		URL:          f.pageURL(post) + "#" + comment.Anchor,
+		Published:    published(comment.CreationDate),
+		To:           IRIs{Public},
+		Cc:           cc,
+	}, nil
+}
+
+// createActivity wraps a post or comment for delivery
+func createActivity(object Object) Activity {
+	return Activity{
+		ID:        object.ID + "#create",
+		Type:      "Create",
+		Actor:     object.AttributedTo,
+		Object:    rawObject(object),
+		Published: object.Published,
+		To:        object.To,
+		Cc:        object.Cc,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, contentType string, v any) {
+	w.Header().Set("Content-Type", contentType)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+	}
+}
+
+// serveObject serves the actor, post or comment at /u/...,
+// content of remote users redirects to its server
+func (f *Federation) serveObject(w http.ResponseWriter, r *http.Request) {
+	location := strings.TrimPrefix(r.URL.Path, "/u")
+	username, resource, _ := strings.Cut(strings.TrimPrefix(location, "/"), "/")
+	if f.strg.IsRemoteUser(username) {
+		id, ok := f.strg.ObjectID(location)
+		if resource == "" {
+			id, _, ok = f.strg.RemoteUser(username)
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, id, http.StatusFound)
+		return
+	}
+
+	var v any
+	var err error
+	switch {
+	case resource == "":
+		if !f.localUser(username) {
+			http.NotFound(w, r)
+			return
+		}
+		v, err = f.actor(username)
+	case strings.HasPrefix(resource, "post:"):
+		var article Object
+		article, err = f.article(location)
+		article.Context = activityContext
+		v = article
+	case strings.HasPrefix(resource, "comment:"):
+		var note Object
+		note, err = f.note(location)
+		note.Context = activityContext
+		v = note
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, ContentType, v)
+}
+
+type jrdLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
+
+type jrd struct {
+	Subject string    `json:"subject"`
+	Aliases []string  `json:"aliases,omitempty"`
+	Links   []jrdLink `json:"links"`
+}
+
+// webfinger finds local users by acct:<user>@<host> or by actor ID
+func (f *Federation) webfinger(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	var username string
+	if acct, ok := strings.CutPrefix(resource, "acct:"); ok {
+		user, host, _ := strings.Cut(acct, "@")
+		if host == f.host {
+			username = user
+		}
+	} else if location, ok := f.location(resource); ok && strings.Count(location, "/") == 1 {
+		username = strings.TrimPrefix(location, "/")
+	}
+	if username == "" || !f.localUser(username) {
+		http.NotFound(w, r)
+		return
+	}
+
+	id := f.actorID(username)
+	writeJSON(w, "application/jrd+json", jrd{
+		Subject: "acct:" + username + "@" + f.host,
+		Aliases: []string{id},
+		Links: []jrdLink{
+			{Rel: "self", Type: ContentType, Href: id},
+			{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: id},
+		},
+	})
+}
+
+// outbox lists the newest posts of a user
+func (f *Federation) outbox(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("user")
+	if !f.localUser(username) {
+		http.NotFound(w, r)
+		return
+	}
+
+	articles := f.strg.GetUserArticles(username)
+	slices.SortFunc(articles, func(a, b tmpl.ArticleItem) int {
+		return postID(b.PostLink) - postID(a.PostLink)
+	})
+	outbox := Collection{
+		Context:    activityContext,
+		ID:         f.endpoint(username, "outbox"),
+		Type:       "OrderedCollection",
+		TotalItems: len(articles),
+	}
+	for _, item := range articles[:min(len(articles), outboxSize)] {
+		article, err := f.article(strings.TrimPrefix(item.PostLink, "/u"))
+		if err != nil {
+			continue
+		}
+		outbox.OrderedItems = append(outbox.OrderedItems, createActivity(article))
+	}
+	writeJSON(w, ContentType, outbox)
+}
+
+// followers only tells how many followers a user has
+func (f *Federation) followers(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("user")
+	if !f.localUser(username) {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, ContentType, Collection{
+		Context:    activityContext,
+		ID:         f.endpoint(username, "followers"),
+		Type:       "OrderedCollection",
+		TotalItems: len(f.strg.GetFollowers("/" + username)),
+	})
+}
+
+// postID returns the ID of a post from its link
+func postID(link string) int {
+	_, id, _ := strings.Cut(link, "/post:")
+	n, _ := strconv.Atoi(id)
+	return n
+}
+
+// get fetches a JSON document of another server
+func (f *Federation) get(ctx context.Context, rawURL, accept string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s", rawURL, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", rawURL, err)
+	}
+	return nil
+}
+
+// fetchActor returns the actor with the given ID, cached for actorCacheTTL.
+// A refresh fetches a cached actor again, at most once until it expires,
+// so bad signatures can't make the server fetch it on every request.
+func (f *Federation) fetchActor(ctx context.Context, id string, refresh bool) (Actor, error) {
+	f.mu.Lock()
+	cached, ok := f.actors[id]
+	f.mu.Unlock()
+	if ok && time.Since(cached.fetched) < actorCacheTTL && (!refresh || cached.refreshed) {
+		return cached.actor, nil
+	}
+
+	var actor Actor
+	if err := f.get(ctx, id, ContentType, &actor); err != nil {
+		return Actor{}, err
+	}
+	if actor.ID != id || actor.Inbox == "" || actor.PublicKey.PublicKeyPem == "" {
+		return Actor{}, fmt.Errorf("%s isn't a valid actor", id)
+	}
+
+	f.mu.Lock()
+	f.actors[id] = cachedActor{actor: actor, fetched: time.Now(), refreshed: ok && refresh}
+	f.mu.Unlock()
+	return actor, nil
+}
+
+// lookup finds the actor of a user@host handle with WebFinger
+func (f *Federation) lookup(ctx context.Context, handle string) (Actor, error) {
+	_, host, _ := strings.Cut(handle, "@")
+	u := url.URL{
+		Scheme:   f.scheme,
+		Host:     host,
+		Path:     "/.well-known/webfinger",
+		RawQuery: url.Values{"resource": {"acct:" + handle}}.Encode(),
+	}
+	var account jrd
+	if err := f.get(ctx, u.String(), "application/jrd+json", &account); err != nil {
+		return Actor{}, err
+	}
+	for _, link := range account.Links {
+		if link.Rel == "self" && (link.Type == ContentType || strings.HasPrefix(link.Type, "application/ld+json")) {
+			return f.fetchActor(ctx, link.Href, false)
+		}
+	}
+	return Actor{}, fmt.Errorf("no actor found for %s", handle)
+}
+
+// remoteUser returns the remote user of an actor, creating it
+// the first time the actor shows up
+func (f *Federation) remoteUser(actor Actor) (string, error) {
+	if handle, ok := f.strg.RemoteUserByActor(actor.ID); ok {
+		return handle, nil
+	}
+	u, err := url.Parse(actor.ID)
+	if err != nil || actor.PreferredUsername == "" {
+		return "", fmt.Errorf("%s has no username", actor.ID)
+	}
+	handle := actor.PreferredUsername + "@" + u.Host
+	if err := f.strg.AddRemoteUser(handle, actor.ID, actor.Inbox); err != nil {
+		return "", fmt.Errorf("failed to add remote user %s: %w", handle, err)
+	}
+	return handle, nil
+}
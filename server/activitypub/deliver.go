@@ -0,0 +1,236 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forumapp/events"
+	"forumapp/storage"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	queueSize    = 256
+	maxInFlight  = 4
+	maxAttempts  = 5
+	firstBackoff = 30 * time.Second // Doubles after every failed attempt
+)
+
+// delivery is an activity signed by a local user for a remote inbox
+type delivery struct {
+	signer string
+	inbox  string
+	body   []byte
+}
+
+// statusError is a response of a remote inbox other than 2xx
+type statusError struct {
+	status int
+	text   string
+}
+
+func (e statusError) Error() string {
+	return "inbox responded with " + e.text
+}
+
+// send queues an activity for delivery to a remote inbox
+func (f *Federation) send(signer, inbox string, activity Activity) error {
+	activity.Context = activityContext
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return fmt.Errorf("failed to encode activity: %w", err)
+	}
+	select {
+	case f.deliveries <- delivery{signer: signer, inbox: inbox, body: body}:
+		return nil
+	default:
+		return fmt.Errorf("delivery queue is full, dropped %s to %s", activity.Type, inbox)
+	}
+}
+
+// sendAll queues an activity for every distinct inbox
+func (f *Federation) sendAll(signer string, inboxes []string, activity Activity) error {
+	slices.Sort(inboxes)
+	var errs []error
+	for _, inbox := range slices.Compact(inboxes) {
+		if err := f.send(signer, inbox, activity); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run delivers queued activities until ctx is cancelled. Unlike webhook
+// deliveries they aren't stored, activities still queued when the
+// server stops are lost.
+func (f *Federation) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-f.deliveries:
+			go f.deliver(ctx, d)
+		}
+	}
+}
+
+// deliver posts the activity until it succeeds, the inbox refuses it
+// or it runs out of attempts
+func (f *Federation) deliver(ctx context.Context, d delivery) {
+	for attempt := range maxAttempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(firstBackoff << (attempt - 1)):
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case f.slots <- struct{}{}:
+		}
+		err := f.post(ctx, d)
+		<-f.slots
+		if err == nil || errors.Is(err, context.Canceled) {
+			return
+		}
+
+		log.Printf("Failed to deliver activity to %s (attempt %d): %s\n", d.inbox, attempt+1, err)
+		var status statusError
+		if errors.As(err, &status) && status.status >= 400 && status.status < 500 &&
+			status.status != http.StatusTooManyRequests {
+			return
+		}
+	}
+}
+
+// post sends one signed attempt
+func (f *Federation) post(ctx context.Context, d delivery) error {
+	key, err := f.strg.ActorKey(d.signer)
+	if err != nil {
+		return fmt.Errorf("failed to get key of %s: %w", d.signer, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.inbox, bytes.NewReader(d.body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Accept", ContentType)
+	if err := sign(req, f.actorID(d.signer)+"#main-key", key, d.body); err != nil {
+		return err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError{status: resp.StatusCode, text: resp.Status}
+	}
+	return nil
+}
+
+func inboxes(followers []storage.Follower) []string {
+	inboxes := make([]string, 0, len(followers))
+	for _, follower := range followers {
+		inboxes = append(inboxes, follower.Inbox)
+	}
+	return inboxes
+}
+
+// postCreated sends new posts of local users to their followers
+func (f *Federation) postCreated(e events.PostCreated) error {
+	if f.strg.IsRemoteUser(e.Author) {
+		return nil
+	}
+	followers := f.strg.GetFollowers("/" + e.Author)
+	if len(followers) == 0 {
+		return nil
+	}
+	article, err := f.article(e.Location)
+	if err != nil {
+		return err
+	}
+	return f.sendAll(e.Author, inboxes(followers), createActivity(article))
+}
+
+// commentLinked sends new comments of local users to the followers
+// of the thread and of the author, and to remote users they reply to
+func (f *Federation) commentLinked(e events.CommentLinked) error {
+	if f.strg.IsRemoteUser(e.Author) {
+		return nil
+	}
+	recipients := inboxes(f.strg.GetFollowers(e.Post))
+	recipients = append(recipients, inboxes(f.strg.GetFollowers("/"+e.Author))...)
+	for _, location := range []string{e.Parent, e.Post} {
+		username, _, _ := strings.Cut(strings.TrimPrefix(location, "/"), "/")
+		if _, inbox, ok := f.strg.RemoteUser(username); ok {
+			recipients = append(recipients, inbox)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	note, err := f.note(e.Location)
+	if err != nil {
+		return err
+	}
+	return f.sendAll(e.Author, recipients, createActivity(note))
+}
+
+// followActivity is the follow of a remote account by a local user,
+// its ID is recognized again in the answer of the remote server
+func (f *Federation) followActivity(username, handle, actorID string) Activity {
+	return Activity{
+		ID:     f.actorID(username) + "#follows/" + url.PathEscape(handle),
+		Type:   "Follow",
+		Actor:  f.actorID(username),
+		Object: rawObject(actorID),
+	}
+}
+
+func (f *Federation) remoteFollow(e events.RemoteFollow) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*requestTimeout)
+	defer cancel()
+
+	actor, err := f.lookup(ctx, e.Handle)
+	if err == nil {
+		err = f.strg.AddRemoteUser(e.Handle, actor.ID, actor.Inbox)
+	}
+	if err == nil {
+		err = f.send(e.Username, actor.Inbox, f.followActivity(e.Username, e.Handle, actor.ID))
+	}
+	if err != nil {
+		_ = f.strg.SetFollowState(e.Username, e.Handle, storage.FollowFailed)
+		return fmt.Errorf("failed to follow %s: %w", e.Handle, err)
+	}
+	return nil
+}
+
+func (f *Federation) remoteUnfollow(e events.RemoteUnfollow) error {
+	actorID, inbox, ok := f.strg.RemoteUser(e.Handle)
+	if !ok {
+		return nil
+	}
+	follow := f.followActivity(e.Username, e.Handle, actorID)
+	return f.send(e.Username, inbox, Activity{
+		ID:     follow.ID + "/undo",
+		Type:   "Undo",
+		Actor:  follow.Actor,
+		Object: rawObject(follow),
+	})
+}
@@ -0,0 +1,253 @@
+package activitypub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forumapp/storage"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+var (
+	errNotFollowable = errors.New("only users and their posts can be followed")
+	errForged        = errors.New("activity isn't by the actor that signed it")
+)
+
+const (
+	// Titles of posts from servers that have none, like Mastodon
+	maxSummaryLength = 80
+	// Titles are cut to the length allowed on the forum, in bytes
+	maxTitleLength = 200
+)
+
+// inbox receives the activities of other servers for a local user
+func (f *Federation) inbox(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("user")
+	if !f.localUser(username) {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "400 bad request", http.StatusBadRequest)
+		return
+	}
+
+	var activity Activity
+	if err := json.Unmarshal(body, &activity); err != nil {
+		http.Error(w, "400 bad request", http.StatusBadRequest)
+		return
+	}
+	signer, err := f.verify(r.Context(), r, body, activity.Actor)
+	if err != nil {
+		log.Println("Rejected activity:", err)
+		http.Error(w, "401 unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if activity.Actor != signer.ID {
+		log.Println("Rejected activity:", errForged)
+		http.Error(w, "401 unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch activity.Type {
+	case "Follow":
+		err = f.follow(username, signer, activity, body)
+	case "Undo":
+		err = f.undo(username, signer, activity)
+	case "Accept", "Reject":
+		err = f.answer(username, signer, activity)
+	case "Create":
+		err = f.create(signer, activity)
+	}
+	if err != nil {
+		log.Printf("Error: %s of %s: %s\n", activity.Type, activity.Actor, err)
+		http.Error(w, "400 bad request", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// follow adds a follower of the user or of one of their posts
+// and accepts the follow right away
+func (f *Federation) follow(username string, signer Actor, activity Activity, body []byte) error {
+	location, ok := f.location(rawID(activity.Object))
+	if !ok || (location != "/"+username && !strings.HasPrefix(location, "/"+username+"/post:")) {
+		return errNotFollowable
+	}
+	err := f.strg.AddFollower(location, storage.Follower{ActorID: signer.ID, Inbox: signer.Inbox})
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256([]byte(activity.ID))
+	return f.send(username, signer.Inbox, Activity{
+		ID:     f.actorID(username) + "#accepts/" + hex.EncodeToString(sum[:8]),
+		Type:   "Accept",
+		Actor:  f.actorID(username),
+		Object: body,
+	})
+}
+
+func (f *Federation) undo(username string, signer Actor, activity Activity) error {
+	var undone Activity
+	if err := json.Unmarshal(activity.Object, &undone); err != nil {
+		// Only the ID of the follow, it can only be a follow of the user
+		return f.strg.RemoveFollower("/"+username, signer.ID)
+	}
+	if undone.Type != "Follow" {
+		return nil
+	}
+	if undone.Actor != signer.ID {
+		return errForged
+	}
+	location, ok := f.location(rawID(undone.Object))
+	if !ok {
+		return nil
+	}
+	return f.strg.RemoveFollower(location, signer.ID)
+}
+
+// answer records whether a follow of a local user was accepted
+func (f *Federation) answer(username string, signer Actor, activity Activity) error {
+	if !strings.HasPrefix(rawID(activity.Object), f.actorID(username)+"#follows/") {
+		return nil
+	}
+	handle, ok := f.strg.RemoteUserByActor(signer.ID)
+	if !ok {
+		return nil
+	}
+	state := storage.FollowAccepted
+	if activity.Type == "Reject" {
+		state = storage.FollowRejected
+	}
+	err := f.strg.SetFollowState(username, handle, state)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// create stores replies to local content as comments and the posts
+// of accounts followed by local users as posts, anything else is ignored
+func (f *Federation) create(signer Actor, activity Activity) error {
+	var object Object
+	if err := json.Unmarshal(activity.Object, &object); err != nil {
+		return fmt.Errorf("failed to decode object: %w", err)
+	}
+	if object.Type != "Note" && object.Type != "Article" {
+		return nil
+	}
+	if object.AttributedTo != signer.ID {
+		return errForged
+	}
+
+	f.createMu.Lock()
+	defer f.createMu.Unlock()
+
+	if _, ok := f.strg.ObjectLocation(object.ID); ok {
+		return nil
+	}
+	text := htmlToText(object.Content)
+	// Other instances of this forum send the Markdown
+	if object.Source != nil && object.Source.MediaType == "text/markdown" {
+		text = object.Source.Content
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	handle, err := f.remoteUser(signer)
+	if err != nil {
+		return err
+	}
+
+	if object.InReplyTo != "" {
+		parent, ok := f.location(object.InReplyTo)
+		if !ok {
+			return nil
+		}
+		return f.addComment(handle, parent, text, object.ID)
+	}
+
+	if len(f.strg.LocalFollowers(handle)) == 0 {
+		return nil
+	}
+	title := shorten(strings.TrimSpace(object.Name), maxTitleLength)
+	if title == "" {
+		title = summarize(text)
+	}
+	id, err := f.strg.AddPost(handle, title, text)
+	if err != nil {
+		return err
+	}
+	return f.strg.SetObjectID(fmt.Sprintf("/%s/post:%d", handle, id), object.ID)
+}
+
+// addComment adds a comment of a remote user to a post or comment
+func (f *Federation) addComment(handle, parent, text, objectID string) error {
+	switch {
+	case strings.Contains(parent, "/post:"):
+		if _, err := f.strg.GetPost(parent); err != nil {
+			return nil
+		}
+	case strings.Contains(parent, "/comment:"):
+		if _, err := f.strg.GetComment(parent); err != nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+	location, err := f.strg.Reply(handle, text, parent)
+	if err != nil {
+		return err
+	}
+	return f.strg.SetObjectID(location, objectID)
+}
+
+var (
+	lineBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</p>\s*`)
+	stripTags  = bluemonday.StrictPolicy()
+)
+
+// htmlToText keeps the text and line breaks of HTML content
+func htmlToText(content string) string {
+	content = lineBreaks.ReplaceAllStringFunc(content, func(tag string) string {
+		if strings.HasPrefix(strings.ToLower(tag), "<br") {
+			return "\n"
+		}
+		return "\n\n"
+	})
+	return strings.TrimSpace(html.UnescapeString(stripTags.Sanitize(content)))
+}
+
+// summarize returns the first line of text, shortened to maxSummaryLength
+func summarize(text string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	if utf8.RuneCountInString(title) > maxSummaryLength {
+		runes := []rune(title)
+		title = strings.TrimSpace(string(runes[:maxSummaryLength-1])) + "…"
+	}
+	return shorten(title, maxTitleLength)
+}
+
+// shorten cuts title to at most size bytes without splitting a character
+func shorten(title string, size int) string {
+	if len(title) <= size {
+		return title
+	}
+	end := size - len("…")
+	for end > 0 && !utf8.RuneStart(title[end]) {
+		end--
+	}
+	return strings.TrimSpace(title[:end]) + "…"
+}
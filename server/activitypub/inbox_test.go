@@ -0,0 +1,171 @@
+package activitypub
+
+import (
+	"bytes"
+	"encoding/json"
+	"forumapp/events"
+	"forumapp/storage"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// newTestFederation serves the inbox of a forum over an empty storage
+// tree with the user alice and her post, storage paths are relative to
+// the server directory like in production
+func newTestFederation(t *testing.T) (*httptest.Server, *Federation) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, sub := range []string{"server", "storage"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(filepath.Join(dir, "server"))
+
+	strg, err := storage.NewStorage(events.NewBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := strg.AddUser("alice@example.com", "alice", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strg.AddPost("alice", "Hello", "First post"); err != nil {
+		t.Fatal(err)
+	}
+
+	f := New(strg, events.NewBus(), "https://forum.example")
+	mux := http.NewServeMux()
+	f.Register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, f
+}
+
+func TestInboxCreate(t *testing.T) {
+	srv, f := newTestFederation(t)
+	remote := newRemoteActor(t, "bob")
+	forger := newRemoteActor(t, "mallory")
+	post := f.pageURL("/alice/post:0")
+
+	tests := []struct {
+		name   string
+		signer *remoteActor
+		// Actor of the activity and author of the note, the signer when nil
+		author *remoteActor
+		id     string
+		want   int
+		stored bool
+	}{
+		{name: "reply", signer: remote, id: "/notes/1", want: http.StatusAccepted, stored: true},
+		{name: "delivered again", signer: remote, id: "/notes/1", want: http.StatusAccepted, stored: true},
+		{name: "unsigned", id: "/notes/2", want: http.StatusUnauthorized},
+		{name: "signed by another actor", signer: forger, author: remote, id: "/notes/3", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			author := tt.author
+			if author == nil {
+				author = tt.signer
+			}
+			if author == nil {
+				author = remote
+			}
+			noteID := author.srv.URL + tt.id
+			body, err := json.Marshal(Activity{
+				ID:    noteID + "/activity",
+				Type:  "Create",
+				Actor: author.actor.ID,
+				Object: rawObject(Object{
+					ID:           noteID,
+					Type:         "Note",
+					AttributedTo: author.actor.ID,
+					InReplyTo:    post,
+					Content:      "<p>Nice post</p>",
+					To:           IRIs{Public},
+				}),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/ap/u/alice/inbox", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", ContentType)
+			if tt.signer != nil {
+				if err := sign(req, tt.signer.actor.PublicKey.ID, tt.signer.key, body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+
+			location, ok := f.strg.ObjectLocation(noteID)
+			if ok != tt.stored {
+				t.Fatalf("note stored = %v, want %v", ok, tt.stored)
+			}
+			if !ok {
+				return
+			}
+			comment, err := f.strg.GetComment(location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if comment.Text != "Nice post" {
+				t.Errorf("comment text = %q, want %q", comment.Text, "Nice post")
+			}
+		})
+	}
+
+	got, err := f.strg.GetPost("/alice/post:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Comments) != 1 {
+		t.Errorf("post has %d comments, want 1", len(got.Comments))
+	}
+}
+
+func TestShorten(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{name: "short", title: "Hello", want: "Hello"},
+		{name: "at the limit", title: strings.Repeat("a", maxTitleLength), want: strings.Repeat("a", maxTitleLength)},
+		{name: "too long", title: strings.Repeat("a", maxTitleLength+1), want: strings.Repeat("a", maxTitleLength-len("…")) + "…"},
+		// 3 bytes each, the cut can't split one
+		{name: "multibyte", title: strings.Repeat("語", maxTitleLength), want: strings.Repeat("語", (maxTitleLength-len("…"))/3) + "…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shorten(tt.title, maxTitleLength)
+			if got != tt.want {
+				t.Errorf("shorten() = %q, want %q", got, tt.want)
+			}
+			if len(got) > maxTitleLength || !utf8.ValidString(got) {
+				t.Errorf("shorten() = %q isn't a valid title", got)
+			}
+		})
+	}
+
+	// Summaries of long lines of wide characters fit too
+	if got := summarize(strings.Repeat("語", maxSummaryLength+1)); len(got) > maxTitleLength {
+		t.Errorf("summarize() is %d bytes long, want at most %d", len(got), maxTitleLength)
+	}
+}
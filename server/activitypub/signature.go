@@ -0,0 +1,193 @@
+package activitypub
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HTTP signatures as signed and checked by Mastodon and most other
+// servers: draft-cavage-http-signatures with rsa-sha256
+const (
+	signedHeaders = "(request-target) host date digest"
+	// Requests with a Date further away are rejected
+	maxClockSkew = 12 * time.Hour
+)
+
+var errSignature = errors.New("invalid HTTP signature")
+
+func publicKeyPEM(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+func parsePublicKey(keyPEM string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("%w: invalid public key", errSignature)
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid public key: %w", errSignature, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: only RSA keys are supported", errSignature)
+	}
+	return rsaKey, nil
+}
+
+// digest returns the Digest header of body
+func digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func signingString(r *http.Request, headers []string) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, header := range headers {
+		switch header {
+		case "(request-target)":
+			lines = append(lines, header+": "+strings.ToLower(r.Method)+" "+r.URL.RequestURI())
+		case "host":
+			host := r.Host
+			if host == "" {
+				host = r.URL.Host
+			}
+			lines = append(lines, header+": "+host)
+		default:
+			values := r.Header.Values(header)
+			if len(values) == 0 {
+				return "", fmt.Errorf("%w: signed header %s is missing", errSignature, header)
+			}
+			lines = append(lines, header+": "+strings.Join(values, ", "))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// sign adds the Date, Digest and Signature headers to an outgoing request
+func sign(req *http.Request, keyID string, key *rsa.PrivateKey, body []byte) error {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Digest", digest(body))
+
+	signed, err := signingString(req, strings.Fields(signedHeaders))
+	if err != nil {
+		return err
+	}
+	hashed := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	req.Header.Set("Signature", fmt.Sprintf(
+		`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, signedHeaders, base64.StdEncoding.EncodeToString(signature),
+	))
+	return nil
+}
+
+// parseSignature splits a Signature header into its parameters
+func parseSignature(header string) map[string]string {
+	params := map[string]string{}
+	for part := range strings.SplitSeq(header, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		params[name] = strings.Trim(value, `"`)
+	}
+	return params
+}
+
+// verify checks the signature of an incoming request for an activity
+// of actorID and returns the actor that signed it
+func (f *Federation) verify(ctx context.Context, r *http.Request, body []byte, actorID string) (Actor, error) {
+	params := parseSignature(r.Header.Get("Signature"))
+	keyID, signature := params["keyId"], params["signature"]
+	headers := strings.Fields(params["headers"])
+	if keyID == "" || signature == "" {
+		return Actor{}, fmt.Errorf("%w: request isn't signed", errSignature)
+	}
+	// Servers sign for their own actors, keys elsewhere aren't even fetched
+	if !sameHost(keyID, actorID) {
+		return Actor{}, fmt.Errorf("%w: key %s isn't on the server of %s", errSignature, keyID, actorID)
+	}
+	if algorithm := params["algorithm"]; algorithm != "" && algorithm != "rsa-sha256" && algorithm != "hs2019" {
+		return Actor{}, fmt.Errorf("%w: unsupported algorithm %s", errSignature, algorithm)
+	}
+	for _, required := range strings.Fields(signedHeaders) {
+		if !slices.Contains(headers, required) {
+			return Actor{}, fmt.Errorf("%w: %s isn't signed", errSignature, required)
+		}
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil || time.Since(date).Abs() > maxClockSkew {
+		return Actor{}, fmt.Errorf("%w: date is missing or too far off", errSignature)
+	}
+	algorithm, sum, _ := strings.Cut(r.Header.Get("Digest"), "=")
+	if !strings.EqualFold(algorithm, "SHA-256") || "SHA-256="+sum != digest(body) {
+		return Actor{}, fmt.Errorf("%w: digest doesn't match the body", errSignature)
+	}
+	signed, err := signingString(r, headers)
+	if err != nil {
+		return Actor{}, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return Actor{}, fmt.Errorf("%w: %w", errSignature, err)
+	}
+	hashed := sha256.Sum256([]byte(signed))
+
+	keyOwner, _, _ := strings.Cut(keyID, "#")
+	// The key may have changed since the actor was cached
+	for _, refresh := range []bool{false, true} {
+		actor, err := f.fetchActor(ctx, keyOwner, refresh)
+		if err != nil {
+			return Actor{}, fmt.Errorf("%w: failed to get key %s: %w", errSignature, keyID, err)
+		}
+		if actor.PublicKey.ID != keyID {
+			return Actor{}, fmt.Errorf("%w: %s isn't the key of %s", errSignature, keyID, keyOwner)
+		}
+		key, err := parsePublicKey(actor.PublicKey.PublicKeyPem)
+		if err != nil {
+			return Actor{}, err
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], decoded) == nil {
+			return actor, nil
+		}
+	}
+	return Actor{}, fmt.Errorf("%w: signature doesn't match key %s", errSignature, keyID)
+}
+
+// sameHost reports whether both URLs are on the same host
+func sameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Host != "" && strings.EqualFold(ua.Host, ub.Host)
+}
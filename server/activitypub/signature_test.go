@@ -0,0 +1,154 @@
+package activitypub
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// remoteActor serves an actor with a new key, like another server would
+type remoteActor struct {
+	srv   *httptest.Server
+	actor Actor
+	key   *rsa.PrivateKey
+}
+
+func newRemoteActor(t *testing.T, username string) *remoteActor {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := publicKeyPEM(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ra := &remoteActor{key: key}
+	ra.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users/"+username {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, ContentType, ra.actor)
+	}))
+	t.Cleanup(ra.srv.Close)
+
+	id := ra.srv.URL + "/users/" + username
+	ra.actor = Actor{
+		ID:                id,
+		Type:              "Person",
+		PreferredUsername: username,
+		Inbox:             id + "/inbox",
+		PublicKey:         PublicKey{ID: id + "#main-key", Owner: id, PublicKeyPem: keyPEM},
+	}
+	return ra
+}
+
+// resign signs req again after a signed header was changed
+func resign(t *testing.T, req *http.Request, keyID string, key *rsa.PrivateKey) {
+	t.Helper()
+	signed, err := signingString(req, strings.Fields(signedHeaders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := parseSignature(req.Header.Get("Signature"))
+	req.Header.Set("Signature", strings.Replace(req.Header.Get("Signature"),
+		params["signature"], base64.StdEncoding.EncodeToString(signature), 1))
+}
+
+func TestVerify(t *testing.T) {
+	remote := newRemoteActor(t, "bob")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"type":"Create"}`)
+
+	tests := []struct {
+		name  string
+		keyID string
+		key   *rsa.PrivateKey
+		// Date of the request relative to now
+		skew time.Duration
+		// Body received, body is sent when nil
+		received []byte
+		wantErr  bool
+	}{
+		{name: "good signature"},
+		{name: "wrong key", key: otherKey, wantErr: true},
+		{name: "small clock skew", skew: -time.Hour},
+		{name: "date too old", skew: -maxClockSkew - time.Minute, wantErr: true},
+		{name: "date too far ahead", skew: maxClockSkew + time.Minute, wantErr: true},
+		{name: "key on another host", keyID: "https://elsewhere.example/users/bob#main-key", wantErr: true},
+		{name: "other key of the actor", keyID: remote.actor.ID + "#other-key", wantErr: true},
+		{name: "invalid digest", received: []byte(`{"type":"Delete"}`), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Federation{client: remote.srv.Client(), actors: map[string]cachedActor{}}
+			keyID, key, received := tt.keyID, tt.key, tt.received
+			if keyID == "" {
+				keyID = remote.actor.PublicKey.ID
+			}
+			if key == nil {
+				key = remote.key
+			}
+			if received == nil {
+				received = body
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "https://forum.example/ap/u/alice/inbox", bytes.NewReader(body))
+			if err := sign(req, keyID, key, body); err != nil {
+				t.Fatal(err)
+			}
+			if tt.skew != 0 {
+				req.Header.Set("Date", time.Now().Add(tt.skew).UTC().Format(http.TimeFormat))
+				resign(t, req, keyID, key)
+			}
+
+			actor, err := f.verify(t.Context(), req, received, remote.actor.ID)
+			if tt.wantErr {
+				if !errors.Is(err, errSignature) {
+					t.Fatalf("verify() error = %v, want %v", err, errSignature)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verify() error = %v", err)
+			}
+			if actor.ID != remote.actor.ID {
+				t.Errorf("verify() = %s, want %s", actor.ID, remote.actor.ID)
+			}
+		})
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	got := parseSignature(`keyId="https://a.example/u#key", algorithm="rsa-sha256",headers="(request-target) host",signature="YWJj=="`)
+	want := map[string]string{
+		"keyId":     "https://a.example/u#key",
+		"algorithm": "rsa-sha256",
+		"headers":   "(request-target) host",
+		"signature": "YWJj==",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("parseSignature() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"forumapp/session"
+	"forumapp/storage"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	Prefix       = "/api/v1"
+	DocumentPath = "/api/openapi.json"
+	maxBodySize  = 1 << 20
+)
+
+type API struct {
+	ses  *session.Sessions
+	strg *storage.Storage
+}
+
+func New(ses *session.Sessions, strg *storage.Storage) *API {
+	return &API{ses: ses, strg: strg}
+}
+
+// request is passed to endpoint handlers, username is empty
+// for unauthenticated requests
+type request struct {
+	*http.Request
+	username string
+}
+
+// endpoint is both the route and its OpenAPI description,
+// so the document can't drift from the handlers
+type endpoint struct {
+	method  string
+	path    string
+	summary string
+	// Scope an API token needs, empty for public endpoints.
+	// Session tokens have all scopes.
+	scope string
+	// Integer query parameters
+	query []string
+	// Zero values of the request and response bodies,
+	// request is nil for endpoints without a body
+	request  any
+	response any
+	// Status of successful responses
+	status int
+	handle func(a *API, r *request) (any, error)
+}
+
+func (a *API) endpoints() []endpoint {
+	return []endpoint{
+		{
+			method: "POST", path: "/login", summary: "Log in and get a session token",
+			request: LoginRequest{}, response: Token{},
+			status: http.StatusOK, handle: (*API).login,
+		},
+		{
+			method: "POST", path: "/users", summary: "Register a new user",
+			request: RegisterRequest{}, response: Created{},
+			status: http.StatusCreated, handle: (*API).register,
+		},
+		{
+			method: "GET", path: "/users/{user}", summary: "Get the profile of a user",
+			response: User{},
+			status:   http.StatusOK, handle: (*API).getUser,
+		},
+		{
+			method: "GET", path: "/me", summary: "Get the profile of the authenticated user",
+			scope: storage.ScopeRead, response: User{},
+			status: http.StatusOK, handle: (*API).getMe,
+		},
+		{
+			method: "GET", path: "/notifications", summary: "List notifications of the authenticated user",
+			scope: storage.ScopeRead, response: []Notification{},
+			status: http.StatusOK, handle: (*API).listNotifications,
+		},
+		{
+			method: "GET", path: "/posts", summary: "List recently active posts",
+			query: []string{"limit"}, response: []PostSummary{},
+			status: http.StatusOK, handle: (*API).listPosts,
+		},
+		{
+			method: "POST", path: "/posts", summary: "Create a post",
+			scope: storage.ScopePost, request: PostRequest{}, response: Created{},
+			status: http.StatusCreated, handle: (*API).createPost,
+		},
+		{
+			method: "GET", path: "/posts/{user}/{id}", summary: "Get a post with its comment tree",
+			response: Post{},
+			status:   http.StatusOK, handle: (*API).getPost,
+		},
+		{
+			method: "POST", path: "/posts/{user}/{id}/comments", summary: "Comment on a post",
+			scope: storage.ScopePost, request: TextRequest{}, response: Created{},
+			status: http.StatusCreated, handle: (*API).createComment,
+		},
+		{
+			method: "POST", path: "/posts/{user}/{id}/vote", summary: "Vote on a post",
+			scope: storage.ScopeVote, request: VoteRequest{}, response: Vote{},
+			status: http.StatusOK, handle: (*API).votePost,
+		},
+		{
+			method: "POST", path: "/comments/{user}/{id}/replies", summary: "Reply to a comment",
+			scope: storage.ScopePost, request: TextRequest{}, response: Created{},
+			status: http.StatusCreated, handle: (*API).createReply,
+		},
+		{
+			method: "POST", path: "/comments/{user}/{id}/vote", summary: "Vote on a comment",
+			scope: storage.ScopeVote, request: VoteRequest{}, response: Vote{},
+			status: http.StatusOK, handle: (*API).voteComment,
+		},
+	}
+}
+
+// Register adds the API routes and the OpenAPI document to mux
+func (a *API) Register(mux *http.ServeMux) {
+	endpoints := a.endpoints()
+	doc := newDocument(endpoints)
+	for _, e := range endpoints {
+		mux.Handle(e.method+" "+Prefix+e.path, a.handler(e))
+	}
+	mux.Handle(Prefix+"/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, ErrRouteNotFound)
+	}))
+	mux.Handle("GET "+DocumentPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, doc)
+	}))
+}
+
+func (a *API) handler(e endpoint) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &request{Request: r}
+		if e.scope != "" {
+			req.username = a.authenticate(r, e.scope)
+			if req.username == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer scope="`+e.scope+`"`)
+				writeError(w, ErrUnauthorized)
+				return
+			}
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		body, err := e.handle(a, req)
+		if err != nil {
+			writeError(w, errorFor(err))
+			return
+		}
+		writeJSON(w, e.status, body)
+	})
+}
+
+// authenticate returns the user of the bearer token, which is either
+// an API token with the given scope or a session token from /login
+func (a *API) authenticate(r *http.Request, scope string) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	token = strings.TrimSpace(token)
+
+	var username string
+	if strings.HasPrefix(token, storage.TokenPrefix) {
+		username, ok = a.strg.CheckToken(token, scope)
+	} else {
+		username, ok = a.ses.CheckAuth(token)
+	}
+	if !ok {
+		return ""
+	}
+	return username
+}
+
+func decodeJSON(r *request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return ErrBadRequest
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Failed to write JSON response:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, e *Error) {
+	writeJSON(w, e.Status, ErrorResponse{e})
+}
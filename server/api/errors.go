@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"forumapp/storage"
+	"log"
+	"net/http"
+)
+
+// Error is the error object returned by all endpoints:
+//
+//	{"error": {"code": "not_found", "message": "not found"}}
+type Error struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ErrorResponse struct {
+	Error *Error `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var (
+	ErrUnauthorized       = &Error{http.StatusUnauthorized, "unauthorized", "missing or invalid token, or the token lacks the required scope"}
+	ErrInvalidCredentials = &Error{http.StatusUnauthorized, "invalid_credentials", "wrong username or password"}
+	ErrBadRequest         = &Error{http.StatusBadRequest, "bad_request", "malformed request body"}
+	ErrInvalidPost        = &Error{http.StatusBadRequest, "invalid_post", "title and text must not be empty, title must be up to 200 characters"}
+	ErrEmptyText          = &Error{http.StatusBadRequest, "empty_text", "text must not be empty"}
+	ErrRouteNotFound      = &Error{http.StatusNotFound, "not_found", "no such endpoint"}
+	ErrInternal           = &Error{http.StatusInternalServerError, "internal", "internal server error"}
+)
+
+// Storage errors exposed to clients, more specific errors first
+var storageErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{storage.ErrInvalidUserData, http.StatusBadRequest, "invalid_user_data"},
+	{storage.ErrUserExists, http.StatusConflict, "user_exists"},
+	{storage.ErrInvalidURI, http.StatusBadRequest, "invalid_uri"},
+	{storage.ErrUnsupportedResourceType, http.StatusBadRequest, "unsupported_type"},
+	{storage.ErrNotFound, http.StatusNotFound, "not_found"},
+	{storage.ErrInvalidVote, http.StatusBadRequest, "invalid_vote"},
+	{storage.ErrBlocked, http.StatusForbidden, "blocked"},
+	{storage.ErrEmptyMessage, http.StatusBadRequest, "empty_message"},
+	{storage.ErrInvalidProfile, http.StatusBadRequest, "invalid_profile"},
+}
+
+// errorFor converts any error to an error object,
+// unknown errors are logged and hidden from the client
+func errorFor(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	for _, e := range storageErrors {
+		if errors.Is(err, e.err) {
+			return &Error{Status: e.status, Code: e.code, Message: e.err.Error()}
+		}
+	}
+	log.Println("Error: ", err)
+	return ErrInternal
+}
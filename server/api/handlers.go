@@ -0,0 +1,223 @@
+package api
+
+import (
+	"forumapp/storage"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPostsLimit = 10
+	maxPostsLimit     = 100
+	maxTitleLength    = 200
+)
+
+// resourceLocation builds the storage location of a post or comment
+// from the path values of the request
+func resourceLocation(r *request, kind string) (string, error) {
+	user := r.PathValue("user")
+	id := r.PathValue("id")
+	if user == "" || user == "." || user == ".." {
+		return "", storage.ErrNotFound
+	}
+	if _, err := strconv.Atoi(id); err != nil {
+		return "", storage.ErrNotFound
+	}
+	return "/" + user + "/" + kind + ":" + id, nil
+}
+
+func (a *API) login(r *request) (any, error) {
+	var req LoginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	token, err := a.ses.Auth(req.Username, req.Password)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return Token{Token: token}, nil
+}
+
+func (a *API) register(r *request) (any, error) {
+	var req RegisterRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	if err := a.strg.AddUser(req.Email, req.Username, req.Password); err != nil {
+		return nil, err
+	}
+	username := storage.SanitizeUsername(req.Username)
+	return Created{ID: username, URL: "/u/" + username}, nil
+}
+
+func (a *API) getUser(r *request) (any, error) {
+	return a.user(r.PathValue("user"))
+}
+
+// getMe returns the user the token belongs to
+func (a *API) getMe(r *request) (any, error) {
+	return a.user(r.username)
+}
+
+func (a *API) user(username string) (User, error) {
+	profile, err := a.strg.GetProfile(username)
+	if err != nil {
+		return User{}, err
+	}
+	return User{
+		Username:     username,
+		DisplayName:  profile.DisplayName,
+		Bio:          profile.Bio,
+		Website:      profile.Website,
+		JoinDate:     profile.JoinDate,
+		PostCount:    profile.PostCount,
+		CommentCount: profile.CommentCount,
+		Karma:        profile.Karma,
+	}, nil
+}
+
+func (a *API) listNotifications(r *request) (any, error) {
+	notifications := []Notification{}
+	for _, n := range a.strg.GetNotifications(r.username) {
+		notifications = append(notifications, Notification{
+			ID:           n.ID,
+			Kind:         n.Kind,
+			From:         n.From,
+			URL:          n.Link,
+			CreationDate: n.CreationDate,
+			Unread:       n.Unread,
+		})
+	}
+	return notifications, nil
+}
+
+// listPosts returns the recently active posts, ?limit=n sets their number
+func (a *API) listPosts(r *request) (any, error) {
+	limit := defaultPostsLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			return nil, ErrBadRequest
+		}
+		limit = min(n, maxPostsLimit)
+	}
+
+	posts := []PostSummary{}
+	for _, item := range a.strg.GetRecentlyActive(uint(limit)) {
+		if len(posts) == limit {
+			break
+		}
+		posts = append(posts, newPostSummary(item))
+	}
+	return posts, nil
+}
+
+func (a *API) getPost(r *request) (any, error) {
+	location, err := resourceLocation(r, "post")
+	if err != nil {
+		return nil, err
+	}
+	post, err := a.strg.GetPost(location)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+	return Post{
+		ID:           location,
+		Title:        post.Title,
+		Text:         post.Text,
+		Author:       post.Author,
+		AuthorKarma:  post.AuthorKarma,
+		CreationDate: strings.TrimSpace(post.CreationDate),
+		Score:        score(post.Votes),
+		Comments:     newComments(post.Comments),
+	}, nil
+}
+
+func (a *API) createPost(r *request) (any, error) {
+	var req PostRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(req.Title) == "" || strings.TrimSpace(req.Text) == "" ||
+		len(req.Title) > maxTitleLength {
+		return nil, ErrInvalidPost
+	}
+
+	id, err := a.strg.AddPost(r.username, req.Title, req.Text)
+	if err != nil {
+		return nil, err
+	}
+	location := "/" + r.username + "/post:" + strconv.Itoa(id)
+	return Created{ID: location, URL: "/u" + location}, nil
+}
+
+func (a *API) createComment(r *request) (any, error) {
+	location, err := resourceLocation(r, "post")
+	if err != nil {
+		return nil, err
+	}
+	var req TextRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		return nil, ErrEmptyText
+	}
+	if _, err := a.strg.GetPost(location); err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	comment, err := a.strg.Reply(r.username, req.Text, location)
+	if err != nil {
+		return nil, err
+	}
+	return Created{ID: comment, URL: "/u" + location}, nil
+}
+
+func (a *API) createReply(r *request) (any, error) {
+	location, err := resourceLocation(r, "comment")
+	if err != nil {
+		return nil, err
+	}
+	var req TextRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		return nil, ErrEmptyText
+	}
+	post, err := a.strg.RootPost(location)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	comment, err := a.strg.Reply(r.username, req.Text, location)
+	if err != nil {
+		return nil, err
+	}
+	return Created{ID: comment, URL: "/u" + post}, nil
+}
+
+func (a *API) vote(r *request, kind string) (any, error) {
+	location, err := resourceLocation(r, kind)
+	if err != nil {
+		return nil, err
+	}
+	var req VoteRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return nil, err
+	}
+	score, vote, err := a.strg.ToggleVote(r.username, req.Vote, location)
+	if err != nil {
+		return nil, err
+	}
+	return Vote{Score: score, Vote: vote}, nil
+}
+
+func (a *API) votePost(r *request) (any, error) {
+	return a.vote(r, "post")
+}
+
+func (a *API) voteComment(r *request) (any, error) {
+	return a.vote(r, "comment")
+}
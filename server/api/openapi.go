@@ -0,0 +1,202 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI 3.1 document, generated from the endpoint table
+// and the request and response types
+
+type document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       info                             `json:"info"`
+	Servers    []server                         `json:"servers"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components components                       `json:"components"`
+}
+
+type info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type server struct {
+	URL string `json:"url"`
+}
+
+type components struct {
+	Schemas         map[string]*schema        `json:"schemas"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme"`
+	Description string `json:"description"`
+}
+
+type operation struct {
+	Summary     string                `json:"summary"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Parameters  []parameter           `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]response   `json:"responses"`
+}
+
+type parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+}
+
+const (
+	schemaRefPrefix = "#/components/schemas/"
+	securityName    = "bearerAuth"
+)
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+func newDocument(endpoints []endpoint) *document {
+	d := &document{
+		OpenAPI: "3.1.0",
+		Info:    info{Title: "comp.sci Club API", Version: "1"},
+		Servers: []server{{URL: Prefix}},
+		Paths:   map[string]map[string]*operation{},
+		Components: components{
+			Schemas: map[string]*schema{},
+			SecuritySchemes: map[string]securityScheme{
+				securityName: {
+					Type:   "http",
+					Scheme: "bearer",
+					Description: "A session token from POST /login, which has all scopes, " +
+						"or a personal API token with the listed scopes",
+				},
+			},
+		},
+	}
+	errorSchema := d.schemaFor(reflect.TypeFor[ErrorResponse]())
+
+	for _, e := range endpoints {
+		op := &operation{
+			Summary: e.summary,
+			Responses: map[string]response{
+				strconv.Itoa(e.status): jsonResponse(http.StatusText(e.status), d.schemaFor(reflect.TypeOf(e.response))),
+				"default":              jsonResponse("Error", errorSchema),
+			},
+		}
+		if e.scope != "" {
+			op.Security = []map[string][]string{{securityName: {e.scope}}}
+		}
+		for _, match := range pathParam.FindAllStringSubmatch(e.path, -1) {
+			op.Parameters = append(op.Parameters, parameter{
+				Name:     match[1],
+				In:       "path",
+				Required: true,
+				Schema:   &schema{Type: "string"},
+			})
+		}
+		for _, name := range e.query {
+			op.Parameters = append(op.Parameters, parameter{
+				Name:   name,
+				In:     "query",
+				Schema: &schema{Type: "integer"},
+			})
+		}
+		if e.request != nil {
+			op.RequestBody = &requestBody{
+				Required: true,
+				Content: map[string]mediaType{
+					"application/json": {Schema: d.schemaFor(reflect.TypeOf(e.request))},
+				},
+			}
+		}
+
+		if d.Paths[e.path] == nil {
+			d.Paths[e.path] = map[string]*operation{}
+		}
+		d.Paths[e.path][strings.ToLower(e.method)] = op
+	}
+	return d
+}
+
+func jsonResponse(description string, s *schema) response {
+	return response{
+		Description: description,
+		Content:     map[string]mediaType{"application/json": {Schema: s}},
+	}
+}
+
+// schemaFor describes how encoding/json encodes t, named structs
+// become components so recursive types like Comment work
+func (d *document) schemaFor(t reflect.Type) *schema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return d.schemaFor(t.Elem())
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}
+	case reflect.Slice, reflect.Array:
+		return &schema{Type: "array", Items: d.schemaFor(t.Elem())}
+	case reflect.Struct:
+		ref := &schema{Ref: schemaRefPrefix + t.Name()}
+		if _, ok := d.Components.Schemas[t.Name()]; ok {
+			return ref
+		}
+		noAdditional := false
+		s := &schema{
+			Type:                 "object",
+			Properties:           map[string]*schema{},
+			Required:             []string{},
+			AdditionalProperties: &noAdditional,
+		}
+		// Added before the fields, so they can refer to it
+		d.Components.Schemas[t.Name()] = s
+		for i := range t.NumField() {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			s.Properties[name] = d.schemaFor(field.Type)
+			s.Required = append(s.Required, name)
+		}
+		return ref
+	default:
+		panic("api: no schema for type " + t.String())
+	}
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"forumapp/events"
+	"forumapp/session"
+	"forumapp/storage"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestServer serves the API over an empty storage tree, storage
+// paths are relative to the server directory like in production
+func newTestServer(t *testing.T) (*httptest.Server, *storage.Storage) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, sub := range []string{"server", "storage"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(filepath.Join(dir, "server"))
+
+	strg, err := storage.NewStorage(events.NewBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	New(session.NewSessions(), strg).Register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, strg
+}
+
+// TestResponsesMatchDocument calls every endpoint and checks each
+// response body against the OpenAPI document served for it
+func TestResponsesMatchDocument(t *testing.T) {
+	srv, strg := newTestServer(t)
+	doc := newDocument((&API{}).endpoints())
+
+	// Tokens are filled in by the steps that get them
+	tokens := map[string]string{}
+
+	steps := []struct {
+		name   string
+		method string
+		// Path of the endpoint in the document
+		path string
+		// Path requested, path is used when empty
+		url   string
+		token string
+		body  string
+		want  int
+	}{
+		{"register", "POST", "/users", "", "", `{"username":"alice","email":"alice@example.com","password":"pw"}`, http.StatusCreated},
+		{"register other", "POST", "/users", "", "", `{"username":"bob","email":"bob@example.com","password":"pw"}`, http.StatusCreated},
+		{"register taken", "POST", "/users", "", "", `{"username":"alice","email":"alice@example.com","password":"pw"}`, http.StatusConflict},
+		{"register invalid", "POST", "/users", "", "", `{"username":"carol","email":"no-at","password":"pw"}`, http.StatusBadRequest},
+		{"register header in email", "POST", "/users", "", "", `{"username":"carol","email":"carol@example.com\r\nBcc: all@example.com","password":"pw"}`, http.StatusBadRequest},
+		{"register malformed", "POST", "/users", "", "", `{"username":`, http.StatusBadRequest},
+		{"register unknown field", "POST", "/users", "", "", `{"username":"carol","admin":true}`, http.StatusBadRequest},
+		{"login", "POST", "/login", "", "", `{"username":"alice","password":"pw"}`, http.StatusOK},
+		{"login other", "POST", "/login", "", "", `{"username":"bob","password":"pw"}`, http.StatusOK},
+		{"login wrong password", "POST", "/login", "", "", `{"username":"alice","password":"nope"}`, http.StatusUnauthorized},
+		{"user", "GET", "/users/{user}", "/users/alice", "", "", http.StatusOK},
+		{"user missing", "GET", "/users/{user}", "/users/nobody", "", "", http.StatusNotFound},
+		{"me", "GET", "/me", "", "alice", "", http.StatusOK},
+		{"me anonymous", "GET", "/me", "", "", "", http.StatusUnauthorized},
+		{"me bad token", "GET", "/me", "", "invalid", "", http.StatusUnauthorized},
+		{"posts empty", "GET", "/posts", "", "", "", http.StatusOK},
+		{"create post", "POST", "/posts", "", "alice", `{"title":"Hello","text":"First post"}`, http.StatusCreated},
+		{"create post empty title", "POST", "/posts", "", "alice", `{"title":" ","text":"text"}`, http.StatusBadRequest},
+		{"create post long title", "POST", "/posts", "", "alice", `{"title":"` + strings.Repeat("a", maxTitleLength+1) + `","text":"text"}`, http.StatusBadRequest},
+		{"create post anonymous", "POST", "/posts", "", "", `{"title":"Hello","text":"text"}`, http.StatusUnauthorized},
+		{"create post read scope", "POST", "/posts", "", "alice read", `{"title":"Hello","text":"text"}`, http.StatusUnauthorized},
+		{"posts", "GET", "/posts", "", "", "", http.StatusOK},
+		{"posts limit", "GET", "/posts", "/posts?limit=1", "", "", http.StatusOK},
+		{"posts bad limit", "GET", "/posts", "/posts?limit=zero", "", "", http.StatusBadRequest},
+		{"comment", "POST", "/posts/{user}/{id}/comments", "/posts/alice/0/comments", "bob", `{"text":"A comment"}`, http.StatusCreated},
+		{"comment empty", "POST", "/posts/{user}/{id}/comments", "/posts/alice/0/comments", "bob", `{"text":""}`, http.StatusBadRequest},
+		{"comment missing post", "POST", "/posts/{user}/{id}/comments", "/posts/alice/9/comments", "bob", `{"text":"A comment"}`, http.StatusNotFound},
+		{"comment bad id", "POST", "/posts/{user}/{id}/comments", "/posts/alice/x/comments", "bob", `{"text":"A comment"}`, http.StatusNotFound},
+		{"reply", "POST", "/comments/{user}/{id}/replies", "/comments/bob/0/replies", "alice", `{"text":"A reply"}`, http.StatusCreated},
+		{"reply empty", "POST", "/comments/{user}/{id}/replies", "/comments/bob/0/replies", "alice", `{"text":"  "}`, http.StatusBadRequest},
+		{"reply missing comment", "POST", "/comments/{user}/{id}/replies", "/comments/bob/9/replies", "alice", `{"text":"A reply"}`, http.StatusNotFound},
+		{"post", "GET", "/posts/{user}/{id}", "/posts/alice/0", "", "", http.StatusOK},
+		{"post missing", "GET", "/posts/{user}/{id}", "/posts/alice/9", "", "", http.StatusNotFound},
+		{"vote post", "POST", "/posts/{user}/{id}/vote", "/posts/alice/0/vote", "bob", `{"vote":"+"}`, http.StatusOK},
+		{"vote post again", "POST", "/posts/{user}/{id}/vote", "/posts/alice/0/vote", "bob", `{"vote":"+"}`, http.StatusOK},
+		{"vote post invalid", "POST", "/posts/{user}/{id}/vote", "/posts/alice/0/vote", "bob", `{"vote":"x"}`, http.StatusBadRequest},
+		{"vote post missing", "POST", "/posts/{user}/{id}/vote", "/posts/alice/9/vote", "bob", `{"vote":"+"}`, http.StatusNotFound},
+		{"vote comment", "POST", "/comments/{user}/{id}/vote", "/comments/bob/0/vote", "alice", `{"vote":"-"}`, http.StatusOK},
+		{"vote comment missing", "POST", "/comments/{user}/{id}/vote", "/comments/bob/9/vote", "alice", `{"vote":"-"}`, http.StatusNotFound},
+		{"vote comment read scope", "POST", "/comments/{user}/{id}/vote", "/comments/bob/0/vote", "alice read", `{"vote":"-"}`, http.StatusUnauthorized},
+		{"notifications", "GET", "/notifications", "", "alice", "", http.StatusOK},
+		{"notifications read scope", "GET", "/notifications", "", "alice read", "", http.StatusOK},
+		{"notifications anonymous", "GET", "/notifications", "", "", "", http.StatusUnauthorized},
+	}
+
+	succeeded := map[string]bool{}
+	for _, step := range steps {
+		url := step.url
+		if url == "" {
+			url = step.path
+		}
+		req, err := http.NewRequest(step.method, srv.URL+Prefix+url, strings.NewReader(step.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case step.token == "invalid":
+			req.Header.Set("Authorization", "Bearer invalid")
+		case step.token != "":
+			token, ok := tokens[step.token]
+			if !ok {
+				t.Fatalf("%s: no token for %q", step.name, step.token)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != step.want {
+			t.Errorf("%s: %s %s responded %d, want %d: %s", step.name, step.method, url, resp.StatusCode, step.want, raw)
+			continue
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: content type %q, want application/json", step.name, ct)
+		}
+
+		op, ok := doc.Paths[step.path][strings.ToLower(step.method)]
+		if !ok {
+			t.Fatalf("%s: %s %s isn't in the document", step.name, step.method, step.path)
+		}
+		documented, ok := op.Responses[strconv.Itoa(resp.StatusCode)]
+		if !ok {
+			documented = op.Responses["default"]
+		}
+		var body any
+		if err := json.Unmarshal(raw, &body); err != nil {
+			t.Errorf("%s: response isn't JSON: %s", step.name, err)
+			continue
+		}
+		if err := doc.check(documented.Content["application/json"].Schema, body, "$"); err != nil {
+			t.Errorf("%s: response of %s %s doesn't match the document: %s", step.name, step.method, step.path, err)
+		}
+
+		if resp.StatusCode < 300 {
+			succeeded[step.method+" "+step.path] = true
+		}
+		// Later steps authenticate as the users that logged in
+		if step.path == "/login" && resp.StatusCode == http.StatusOK {
+			var token Token
+			if err := json.Unmarshal(raw, &token); err != nil {
+				t.Fatal(err)
+			}
+			var login LoginRequest
+			_ = json.Unmarshal([]byte(step.body), &login)
+			tokens[login.Username] = token.Token
+			readToken, err := strg.CreateToken(login.Username, "read only", []string{storage.ScopeRead})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens[login.Username+" read"] = readToken
+		}
+	}
+
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			if !succeeded[strings.ToUpper(method)+" "+path] {
+				t.Errorf("%s %s has no successful call in the test", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+// check reports the first place where the decoded JSON v
+// doesn't match the schema s
+func (d *document) check(s *schema, v any, path string) error {
+	if s.Ref != "" {
+		return d.check(d.Components.Schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)], v, path)
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %s", path, jsonType(v))
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: missing property %q", path, name)
+			}
+		}
+		for name, value := range object {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: unexpected property %q", path, name)
+				}
+				continue
+			}
+			if err := d.check(property, value, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %s", path, jsonType(v))
+		}
+		for i, item := range array {
+			if err := d.check(s.Items, item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s: expected integer, got %s", path, jsonType(v))
+		}
+	default:
+		if jsonType(v) != s.Type {
+			return fmt.Errorf("%s: expected %s, got %s", path, s.Type, jsonType(v))
+		}
+	}
+	return nil
+}
+
+func jsonType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
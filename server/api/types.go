@@ -0,0 +1,133 @@
+package api
+
+import (
+	"forumapp/tmpl"
+	"strconv"
+	"strings"
+)
+
+// Request and response bodies
+
+type PostSummary struct {
+	ID           string `json:"id"`
+	Title        string `json:"title"`
+	Author       string `json:"author"`
+	AuthorKarma  int    `json:"author_karma"`
+	CreationDate string `json:"creation_date"`
+	URL          string `json:"url"`
+}
+
+type Post struct {
+	ID           string    `json:"id"`
+	Title        string    `json:"title"`
+	Text         string    `json:"text"`
+	Author       string    `json:"author"`
+	AuthorKarma  int       `json:"author_karma"`
+	CreationDate string    `json:"creation_date"`
+	Score        int       `json:"score"`
+	Comments     []Comment `json:"comments"`
+}
+
+type Comment struct {
+	ID           string    `json:"id"`
+	Parent       string    `json:"parent"`
+	Text         string    `json:"text"`
+	Author       string    `json:"author"`
+	AuthorKarma  int       `json:"author_karma"`
+	CreationDate string    `json:"creation_date"`
+	Score        int       `json:"score"`
+	Replies      []Comment `json:"replies"`
+}
+
+type User struct {
+	Username     string `json:"username"`
+	DisplayName  string `json:"display_name"`
+	Bio          string `json:"bio"`
+	Website      string `json:"website"`
+	JoinDate     string `json:"join_date"`
+	PostCount    int    `json:"post_count"`
+	CommentCount int    `json:"comment_count"`
+	Karma        int    `json:"karma"`
+}
+
+type Notification struct {
+	ID           string `json:"id"`
+	Kind         string `json:"kind"`
+	From         string `json:"from"`
+	URL          string `json:"url"`
+	CreationDate string `json:"creation_date"`
+	Unread       bool   `json:"unread"`
+}
+
+type Vote struct {
+	Score int    `json:"score"`
+	Vote  string `json:"vote"`
+}
+
+type Created struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+type Token struct {
+	Token string `json:"token"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type PostRequest struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+type TextRequest struct {
+	Text string `json:"text"`
+}
+
+type VoteRequest struct {
+	// "+" upvotes, "-" downvotes, "0" retracts,
+	// voting the same way again retracts as well
+	Vote string `json:"vote"`
+}
+
+func score(votes string) int {
+	n, _ := strconv.Atoi(strings.TrimSpace(votes))
+	return n
+}
+
+func newPostSummary(item tmpl.ArticleItem) PostSummary {
+	return PostSummary{
+		ID:           strings.TrimPrefix(item.PostLink, "/u"),
+		Title:        item.Title,
+		Author:       item.Author,
+		AuthorKarma:  item.AuthorKarma,
+		CreationDate: strings.TrimSpace(item.CreationDate),
+		URL:          item.PostLink,
+	}
+}
+
+func newComments(comments []tmpl.Comment) []Comment {
+	result := []Comment{}
+	for _, c := range comments {
+		result = append(result, Comment{
+			ID:           c.UserLocation,
+			Parent:       strings.TrimSpace(c.Location),
+			Text:         c.Text,
+			Author:       c.Author,
+			AuthorKarma:  c.AuthorKarma,
+			CreationDate: strings.TrimSpace(c.CreationDate),
+			Score:        score(c.Votes),
+			Replies:      newComments(c.Replies),
+		})
+	}
+	return result
+}
@@ -0,0 +1,53 @@
+// Command webhook-receiver prints the webhook deliveries it receives,
+// for trying out webhooks locally:
+//
+//	go run ./cmd/webhook-receiver -secret <secret of the webhook>
+//
+// and add http://localhost:9090/ on /admin/webhooks.
+package main
+
+import (
+	"crypto/hmac"
+	"flag"
+	"forumapp/webhook"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9090", "Listen address")
+	secret := flag.String("secret", "", "Webhook secret, signatures aren't checked if empty")
+	fail := flag.Int("fail", 0, "Respond with 500 to the first n deliveries, to try out retries")
+	flag.Parse()
+
+	var received atomic.Int64
+	http.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if *secret != "" {
+			expected := webhook.Sign(*secret, body)
+			if !hmac.Equal([]byte(expected), []byte(r.Header.Get(webhook.SignatureHeader))) {
+				log.Println("Invalid signature of delivery", r.Header.Get(webhook.DeliveryHeader))
+				http.Error(w, "invalid signature", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		n := received.Add(1)
+		log.Printf("Delivery %s (%s): %s\n",
+			r.Header.Get(webhook.DeliveryHeader), r.Header.Get(webhook.EventHeader), body)
+		if n <= int64(*fail) {
+			http.Error(w, "failing on purpose", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	log.Println("Listening on", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,107 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"forumapp/mail"
+	"forumapp/storage"
+	"forumapp/tmpl"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	checkInterval = 10 * time.Minute
+	topPostsCount = 5
+)
+
+// Scheduler periodically sends digest emails to the users that opted in
+type Scheduler struct {
+	strg      *storage.Storage
+	transport mail.Transport
+	from      string
+	baseURL   string
+}
+
+func NewScheduler(strg *storage.Storage, transport mail.Transport, from, baseURL string) *Scheduler {
+	return &Scheduler{
+		strg:      strg,
+		transport: transport,
+		from:      from,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// Run sends due digests until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		s.SendDue(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue sends digests to all users whose last digest is older than their period
+func (s *Scheduler) SendDue(now time.Time) {
+	t, err := template.ParseFiles("../templates/digest.template")
+	if err != nil {
+		log.Println("Error: failed to parse digest template:", err)
+		return
+	}
+
+	for _, sub := range s.strg.DigestSubscriptions() {
+		period := storage.DigestPeriod(sub.Frequency)
+		if period == 0 || now.Sub(sub.LastDigest) < period {
+			continue
+		}
+		if err := s.send(t, sub); err != nil {
+			log.Println("Error: ", err)
+			continue
+		}
+		if err := s.strg.SetLastDigest(sub.Username, now); err != nil {
+			log.Println("Error: ", err)
+		}
+	}
+}
+
+func (s *Scheduler) send(t *template.Template, sub storage.DigestSubscription) error {
+	digest := tmpl.Digest{
+		Username:      sub.Username,
+		Frequency:     sub.Frequency,
+		BaseURL:       s.baseURL,
+		Notifications: s.strg.GetUnreadNotificationsSince(sub.Username, sub.LastDigest),
+		TopPosts:      s.strg.GetTopPostsSince(sub.LastDigest, topPostsCount),
+	}
+	// Nothing happened, don't bother the user
+	if len(digest.Notifications) == 0 && len(digest.TopPosts) == 0 {
+		return nil
+	}
+
+	var subject, body bytes.Buffer
+	if err := t.ExecuteTemplate(&subject, "subject", digest); err != nil {
+		return fmt.Errorf("failed to render digest subject: %w", err)
+	}
+	if err := t.ExecuteTemplate(&body, "body", digest); err != nil {
+		return fmt.Errorf("failed to render digest for %s: %w", sub.Username, err)
+	}
+
+	err := s.transport.Send(mail.Message{
+		From:    s.from,
+		To:      sub.Email,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimLeft(body.String(), "\n"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send digest to %s: %w", sub.Username, err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Package events is an in-process publish/subscribe bus. Subscribers
+// register for an event type and get every published value of it.
+//
+// Synchronous subscribers run in Publish, in order of subscription,
+// asynchronous subscribers run in their own goroutine, one event at a time.
+// Errors and panics of subscribers are logged and never reach the publisher.
+package events
+
+import (
+	"log"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Events queued for an asynchronous subscriber, more are dropped
+const asyncQueueSize = 256
+
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[reflect.Type][]subscriber
+}
+
+type subscriber struct {
+	name   string
+	handle func(event any) error
+	// Set for asynchronous subscribers
+	queue chan any
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: map[reflect.Type][]subscriber{}}
+}
+
+// Subscribe calls handler for every published E before Publish returns
+func Subscribe[E any](b *Bus, handler func(E) error) {
+	b.add(reflect.TypeFor[E](), subscriber{
+		name:   handlerName(handler),
+		handle: func(event any) error { return handler(event.(E)) },
+	})
+}
+
+// SubscribeAsync calls handler for every published E in a goroutine
+// of the subscriber, events are handled in the order they were published
+func SubscribeAsync[E any](b *Bus, handler func(E) error) {
+	sub := subscriber{
+		name:   handlerName(handler),
+		handle: func(event any) error { return handler(event.(E)) },
+		queue:  make(chan any, asyncQueueSize),
+	}
+	go func() {
+		for event := range sub.queue {
+			sub.call(event)
+		}
+	}()
+	b.add(reflect.TypeFor[E](), sub)
+}
+
+// Publish sends event to all subscribers of its type
+func Publish[E any](b *Bus, event E) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	subs := b.subs[reflect.TypeFor[E]()]
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		if sub.queue == nil {
+			sub.call(event)
+			continue
+		}
+		select {
+		case sub.queue <- event:
+		default:
+			log.Printf("Event queue of %s is full, dropped %T\n", sub.name, event)
+		}
+	}
+}
+
+func (b *Bus) add(t reflect.Type, sub subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs[t] = append(b.subs[t], sub)
+}
+
+// call runs the handler, isolating the publisher from its failures
+func (sub subscriber) call(event any) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Event subscriber %s panicked on %T: %v\n%s", sub.name, event, r, debug.Stack())
+		}
+	}()
+	if err := sub.handle(event); err != nil {
+		log.Printf("Event subscriber %s failed on %T: %s\n", sub.name, event, err)
+	}
+}
+
+// handlerName names subscribers in logs
+func handlerName(handler any) string {
+	return runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+}
@@ -0,0 +1,61 @@
+package events
+
+// Events published by storage after successful writes.
+// Locations are storage locations like /<user>/post:<id>,
+// links are site relative URLs.
+
+type UserRegistered struct {
+	Username string
+}
+
+type PostCreated struct {
+	Location     string
+	Author       string
+	Title        string
+	Text         string
+	CreationDate string
+	Link         string
+}
+
+// CommentCreated is published when the comment is stored,
+// CommentLinked once it is added to the replies of its parent
+type CommentCreated struct {
+	Location     string
+	Parent       string // Post or comment it replies to
+	Post         string
+	PostTitle    string
+	Author       string
+	Text         string
+	CreationDate string
+	Link         string
+}
+
+type CommentLinked struct {
+	Location string
+	Parent   string
+	Post     string
+	Author   string
+}
+
+type VoteCast struct {
+	Voter    string
+	Location string
+	Author   string // Of the post or comment
+	Vote     string // "+", "-" or "0" for a retracted vote
+	Score    int
+	// Highest score milestone reached for the first time, 0 if none
+	Milestone int
+	Link      string
+}
+
+// RemoteFollow is published when a user asks to follow an account
+// of another server, Handle is its user@host
+type RemoteFollow struct {
+	Username string
+	Handle   string
+}
+
+type RemoteUnfollow struct {
+	Username string
+	Handle   string
+}
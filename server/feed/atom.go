@@ -0,0 +1,130 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"time"
+)
+
+const atomContentType = "application/atom+xml; charset=utf-8"
+
+// Browsers show feeds with the stylesheet of the global feed
+const atomStylesheet = `<?xml-stylesheet href="/content/feed.xslt" type="text/xsl"?>` + "\n"
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Author    atomPerson  `xml:"author"`
+	Links     []atomLink  `xml:"link"`
+	Summary   string      `xml:"summary,omitempty"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// encodeAtom writes the feed as an Atom document (RFC 4287)
+func encodeAtom(feed Feed) ([]byte, error) {
+	doc := atomFeed{
+		ID:      feed.Self,
+		Title:   feed.Title,
+		Updated: atomTime(feed.Updated),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: feed.Self},
+			{Rel: "alternate", Type: "text/html", Href: feed.Link},
+		},
+	}
+	for _, entry := range feed.Entries {
+		doc.Entries = append(doc.Entries, atomEntry{
+			ID:    entry.ID,
+			Title: entry.Title,
+			// Nothing is edited, entries change only when they're written
+			Updated:   atomTime(entry.Published),
+			Published: atomTime(entry.Published),
+			Author:    atomPerson{Name: entry.Author, URI: entry.AuthorURL},
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: entry.Link}},
+			Summary:   entry.Summary,
+			Content:   atomContent{Type: "html", Body: entry.HTML},
+		})
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(atomStylesheet)
+	enc := xml.NewEncoder(&b)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode feed %s: %w", feed.Self, err)
+	}
+	b.WriteString("\n")
+	return b.Bytes(), nil
+}
+
+// alternate returns the link to the page of an entry or feed
+func alternate(links []atomLink) string {
+	for _, link := range links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// decodeAtom reads an Atom document into a feed
+func decodeAtom(content []byte) (Feed, error) {
+	var doc atomFeed
+	if err := xml.Unmarshal(content, &doc); err != nil {
+		return Feed{}, fmt.Errorf("failed to decode feed: %w", err)
+	}
+	feed := Feed{Title: doc.Title, Link: alternate(doc.Links)}
+	feed.Updated, _ = time.Parse(time.RFC3339, doc.Updated)
+	for _, entry := range doc.Entries {
+		e := Entry{
+			ID:        entry.ID,
+			Title:     entry.Title,
+			Author:    entry.Author.Name,
+			AuthorURL: entry.Author.URI,
+			Link:      alternate(entry.Links),
+			Summary:   entry.Summary,
+			HTML:      entry.Content.Body,
+		}
+		if entry.Content.Type == "" || entry.Content.Type == "text" {
+			e.HTML = html.EscapeString(entry.Content.Body)
+		}
+		if e.Published, _ = time.Parse(time.RFC3339, entry.Published); e.Published.IsZero() {
+			e.Published, _ = time.Parse(time.RFC3339, entry.Updated)
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	return feed, nil
+}
@@ -0,0 +1,205 @@
+// Package feed serves Atom feeds of the posts of a user, of the comments
+// on a post and of the posts with a tag. Feeds are built from storage on
+// every request, ETag and Last-Modified let readers skip unchanged ones.
+//
+// Every feed, the global one included, is also served as JSON Feed 1.1
+// at the same path with a .json suffix, or to clients preferring JSON.
+package feed
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"forumapp/markup"
+	"forumapp/storage"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxEntries = 50
+	// Atom feed of news, edited by hand
+	globalFeedFile = "../storage/feed"
+	// Length of the plain text summary of an entry
+	summaryLength = 280
+)
+
+// Feed is what a feed shows, independent of its format
+type Feed struct {
+	Title string
+	// URL of the page the feed follows
+	Link string
+	// URL of the feed itself
+	Self    string
+	Updated time.Time
+	Entries []Entry
+}
+
+type Entry struct {
+	ID        string
+	Title     string
+	Author    string
+	AuthorURL string
+	Link      string
+	Published time.Time
+	Summary   string
+	HTML      string
+}
+
+type Feeds struct {
+	strg    *storage.Storage
+	baseURL string
+}
+
+func New(strg *storage.Storage, baseURL string) *Feeds {
+	return &Feeds{strg: strg, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (f *Feeds) Register(mux *http.ServeMux) {
+	for _, suffix := range []string{"", ".json"} {
+		mux.HandleFunc("GET /feed"+suffix, f.globalFeed)
+		mux.HandleFunc("GET /u/{user}/feed"+suffix, f.userFeed)
+		mux.HandleFunc("GET /u/{user}/{post}/feed"+suffix, f.threadFeed)
+		mux.HandleFunc("GET /tags/{tag}/feed"+suffix, f.tagFeed)
+	}
+}
+
+// globalFeed serves the file as it is, JSON Feed is converted from it
+func (f *Feeds) globalFeed(w http.ResponseWriter, r *http.Request) {
+	if !wantsJSON(r) {
+		w.Header().Add("Vary", "Accept")
+		http.ServeFile(w, r, globalFeedFile)
+		return
+	}
+
+	content, err := os.ReadFile(globalFeedFile)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	feed, err := decodeAtom(content)
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	feed.Self = f.baseURL + "/feed"
+	if info, err := os.Stat(globalFeedFile); err == nil {
+		feed.Updated = info.ModTime()
+	}
+	f.serve(w, r, feed)
+}
+
+func (f *Feeds) userFeed(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("user")
+	articles, err := f.strg.UserFeed(username, maxEntries)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f.serve(w, r, f.feed(r, "Posts by "+username, "/u/"+username, articles))
+}
+
+func (f *Feeds) threadFeed(w http.ResponseWriter, r *http.Request) {
+	location := "/" + r.PathValue("user") + "/" + r.PathValue("post")
+	articles, err := f.strg.ThreadFeed(location, maxEntries)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	post, err := f.strg.GetNewsArticle(location)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	feed := f.feed(r, "Comments on "+post.Title, "/u"+location, articles)
+	// A thread without comments changed when the post was written
+	if feed.Updated.IsZero() {
+		feed.Updated = post.CreationDate
+	}
+	f.serve(w, r, feed)
+}
+
+func (f *Feeds) tagFeed(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+	articles, err := f.strg.TagFeed(tag, maxEntries)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	// There's no page of a tag, the feed is all of it
+	f.serve(w, r, f.feed(r, "Posts tagged "+tag, "/tags/"+tag+"/feed", articles))
+}
+
+// feed builds the feed requested by r of the articles, the newest first
+func (f *Feeds) feed(r *http.Request, title, link string, articles []storage.NewsArticle) Feed {
+	feed := Feed{
+		Title: title,
+		Link:  f.baseURL + link,
+		Self:  f.baseURL + strings.TrimSuffix(r.URL.Path, ".json"),
+	}
+	for _, article := range articles {
+		feed.Entries = append(feed.Entries, f.entry(article))
+		if article.CreationDate.After(feed.Updated) {
+			feed.Updated = article.CreationDate
+		}
+	}
+	return feed
+}
+
+func (f *Feeds) entry(article storage.NewsArticle) Entry {
+	entry := Entry{
+		Title:     article.Title,
+		Author:    article.Author,
+		AuthorURL: f.baseURL + "/u/" + article.Author,
+		Link:      f.baseURL + "/u" + article.Location,
+		Published: article.CreationDate,
+		Summary:   summary(article.Text),
+		HTML:      string(markup.Render(article.Text)),
+	}
+	// Comments have no page and no title of their own
+	if len(article.References) > 0 {
+		entry.Title = "Comment by " + article.Author + " on " + article.Title
+		entry.Link = f.baseURL + "/u" + article.References[0] + "#" + storage.Anchor(article.Location)
+	}
+	entry.ID = entry.Link
+	return entry
+}
+
+// summary shortens the text to about summaryLength runes on one line
+func summary(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= summaryLength {
+		return text
+	}
+	runes := []rune(text)[:summaryLength]
+	if i := strings.LastIndex(string(runes), " "); i > 0 {
+		return string(runes)[:i] + "…"
+	}
+	return string(runes) + "…"
+}
+
+// serve writes the feed in the format the reader asks for, or only
+// 304 Not Modified when the reader already has this version of it
+func (f *Feeds) serve(w http.ResponseWriter, r *http.Request, feed Feed) {
+	encode, contentType := encodeAtom, atomContentType
+	if wantsJSON(r) {
+		encode, contentType = encodeJSON, jsonContentType
+	}
+	body, err := encode(feed)
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	// Both formats have their own ETag
+	sum := sha256.Sum256(body)
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", feed.Updated, bytes.NewReader(body))
+}
@@ -0,0 +1,107 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const jsonContentType = "application/feed+json; charset=utf-8"
+
+type jsonFeed struct {
+	Version     string     `json:"version"`
+	Title       string     `json:"title"`
+	HomePageURL string     `json:"home_page_url,omitempty"`
+	FeedURL     string     `json:"feed_url"`
+	Items       []jsonItem `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+type jsonItem struct {
+	ID            string       `json:"id"`
+	URL           string       `json:"url,omitempty"`
+	Title         string       `json:"title,omitempty"`
+	ContentHTML   string       `json:"content_html,omitempty"`
+	ContentText   string       `json:"content_text,omitempty"`
+	Summary       string       `json:"summary,omitempty"`
+	DatePublished string       `json:"date_published,omitempty"`
+	Authors       []jsonAuthor `json:"authors,omitempty"`
+}
+
+// encodeJSON writes the feed as a JSON Feed 1.1 document
+func encodeJSON(feed Feed) ([]byte, error) {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		HomePageURL: feed.Link,
+		FeedURL:     feed.Self + ".json",
+		Items:       []jsonItem{},
+	}
+	for _, entry := range feed.Entries {
+		item := jsonItem{
+			ID:          entry.ID,
+			URL:         entry.Link,
+			Title:       entry.Title,
+			ContentHTML: entry.HTML,
+			Summary:     entry.Summary,
+		}
+		// Items need content, entries of the global feed may only have a summary
+		if entry.HTML == "" {
+			item.ContentText = entry.Summary
+		}
+		if !entry.Published.IsZero() {
+			item.DatePublished = entry.Published.UTC().Format(time.RFC3339)
+		}
+		if entry.Author != "" {
+			item.Authors = []jsonAuthor{{Name: entry.Author, URL: entry.AuthorURL}}
+		}
+		doc.Items = append(doc.Items, item)
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode feed %s: %w", feed.Self, err)
+	}
+	return b.Bytes(), nil
+}
+
+// wantsJSON reports whether the request asks for JSON Feed, by the
+// .json suffix or by preferring JSON over XML in its Accept header
+func wantsJSON(r *http.Request) bool {
+	if strings.HasSuffix(r.URL.Path, ".json") {
+		return true
+	}
+
+	var jsonQ, xmlQ float64
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/feed+json", "application/json":
+			jsonQ = max(jsonQ, q)
+		case "application/atom+xml", "application/xml", "text/xml", "*/*":
+			xmlQ = max(xmlQ, q)
+		}
+	}
+	return jsonQ > xmlQ
+}
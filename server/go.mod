@@ -1,8 +1,19 @@
 module forumapp
 
-go 1.24.1
+go 1.25
 
 require (
+	github.com/alecthomas/chroma/v2 v2.27.0
 	github.com/lmittmann/tint v1.0.7
-	golang.org/x/crypto v0.22.0
+	github.com/microcosm-cc/bluemonday v1.0.27
+	github.com/yuin/goldmark v1.8.6
+	golang.org/x/crypto v0.24.0
+	golang.org/x/image v0.36.0
+)
+
+require (
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/dlclark/regexp2/v2 v2.2.1 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
+	golang.org/x/net v0.26.0 // indirect
 )
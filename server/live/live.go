@@ -0,0 +1,275 @@
+// Package live pushes new comments and score changes of a thread
+// to the browsers showing it, over Server-Sent Events
+package live
+
+import (
+	"bytes"
+	"encoding/json"
+	"forumapp/events"
+	"forumapp/markup"
+	"forumapp/storage"
+	"forumapp/tmpl"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxClients      = 1000
+	maxClientsPerIP = 8
+	// Messages queued for a client, slower clients are disconnected
+	clientQueueSize = 32
+	writeTimeout    = 10 * time.Second
+	// Keeps proxies from closing idle connections
+	pingInterval = 25 * time.Second
+	// Tells EventSource when to reconnect
+	retryMillis = "5000"
+)
+
+type message struct {
+	event string
+	data  []byte
+}
+
+type client struct {
+	ip       string
+	messages chan message
+}
+
+// Hub keeps the connected clients by the post they watch
+type Hub struct {
+	strg    *storage.Storage
+	comment *template.Template
+
+	mu      sync.Mutex
+	threads map[string]map[*client]struct{}
+	perIP   map[string]int
+	total   int
+	closed  bool
+}
+
+func NewHub(strg *storage.Storage, bus *events.Bus) *Hub {
+	h := &Hub{
+		strg: strg,
+		// Precompute template
+		comment: commentTemplate(),
+		threads: map[string]map[*client]struct{}{},
+		perIP:   map[string]int{},
+	}
+	events.SubscribeAsync(bus, h.commentLinked)
+	events.SubscribeAsync(bus, h.voteCast)
+	return h
+}
+
+type commentMessage struct {
+	Anchor       string `json:"anchor"`
+	ParentAnchor string `json:"parent_anchor"`
+	HTML         string `json:"html"`
+}
+
+type scoreMessage struct {
+	// Empty for the post itself
+	Anchor string `json:"anchor"`
+	Score  int    `json:"score"`
+}
+
+func (h *Hub) commentLinked(e events.CommentLinked) error {
+	if !h.watched(e.Post) {
+		return nil
+	}
+	comment, err := h.strg.GetComment(e.Location)
+	if err != nil {
+		return err
+	}
+
+	var html bytes.Buffer
+	if err := h.comment.ExecuteTemplate(&html, "comment", comment); err != nil {
+		return err
+	}
+	return h.broadcast(e.Post, "comment", commentMessage{
+		Anchor:       comment.Anchor,
+		ParentAnchor: storage.Anchor(e.Parent),
+		HTML:         html.String(),
+	})
+}
+
+func (h *Hub) voteCast(e events.VoteCast) error {
+	post, err := h.strg.RootPost(e.Location)
+	if err != nil {
+		return err
+	}
+	if !h.watched(post) {
+		return nil
+	}
+	return h.broadcast(post, "score", scoreMessage{
+		Anchor: storage.Anchor(e.Location),
+		Score:  e.Score,
+	})
+}
+
+func commentTemplate() *template.Template {
+	return template.Must(template.New("").Funcs(template.FuncMap{
+		"indent": func(comments []tmpl.Comment) []tmpl.Comment {
+			for i := range comments {
+				comments[i].Indentation += 20
+			}
+			return comments
+		},
+		"markdown": markup.Render,
+	}).ParseFiles(
+		"../templates/comments.template",
+		"../templates/comment.template",
+	))
+}
+
+func (h *Hub) watched(post string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.threads[post]) > 0
+}
+
+func (h *Hub) broadcast(post, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.threads[post] {
+		select {
+		case c.messages <- message{event: event, data: data}:
+		default:
+			log.Println("Disconnecting slow live update client", c.ip)
+			h.remove(post, c)
+		}
+	}
+	return nil
+}
+
+// add registers a client, it reports false when the limits are reached
+func (h *Hub) add(post, ip string) (*client, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed || h.total >= maxClients || h.perIP[ip] >= maxClientsPerIP {
+		return nil, false
+	}
+	c := &client{ip: ip, messages: make(chan message, clientQueueSize)}
+	if h.threads[post] == nil {
+		h.threads[post] = map[*client]struct{}{}
+	}
+	h.threads[post][c] = struct{}{}
+	h.perIP[ip]++
+	h.total++
+	return c, true
+}
+
+// remove unregisters the client and closes its messages,
+// it does nothing if the client is already removed
+//
+// Only use when `mu` is locked
+func (h *Hub) remove(post string, c *client) {
+	if _, ok := h.threads[post][c]; !ok {
+		return
+	}
+	delete(h.threads[post], c)
+	if len(h.threads[post]) == 0 {
+		delete(h.threads, post)
+	}
+	h.perIP[c.ip]--
+	if h.perIP[c.ip] == 0 {
+		delete(h.perIP, c.ip)
+	}
+	h.total--
+	close(c.messages)
+}
+
+// Close disconnects all clients and refuses new ones,
+// pass it to http.Server.RegisterOnShutdown
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for post, clients := range h.threads {
+		for c := range clients {
+			h.remove(post, c)
+		}
+	}
+}
+
+// ServeHTTP streams the updates of the post at /live/{user}/{post}
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	post := "/" + r.PathValue("user") + "/" + r.PathValue("post")
+	if !strings.HasPrefix(r.PathValue("post"), "post:") {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := h.strg.GetPost(post); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	c, ok := h.add(post, ip)
+	if !ok {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "too many live update connections", http.StatusServiceUnavailable)
+		return
+	}
+	defer func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(post, c)
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	// The server WriteTimeout would end the stream,
+	// so every write gets its own deadline instead
+	rc := http.NewResponseController(w)
+	write := func(chunk string) bool {
+		if err := rc.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+			return false
+		}
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if !write("retry: " + retryMillis + "\n\n") {
+		return
+	}
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			if !write(": ping\n\n") {
+				return
+			}
+		case m, ok := <-c.messages:
+			if !ok {
+				return
+			}
+			if !write("event: " + m.event + "\ndata: " + string(m.data) + "\n\n") {
+				return
+			}
+		}
+	}
+}
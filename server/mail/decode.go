@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+)
+
+var ErrCharset = errors.New("only UTF-8 and ISO-8859-1 are supported")
+
+// DecodeBody undoes the transfer encoding and the charset of a text body,
+// given its Content-Type and Content-Transfer-Encoding headers. Line
+// endings are normalized to "\n".
+func DecodeBody(contentType, transferEncoding string, body io.Reader) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode body: %w", err)
+	}
+
+	charset := "utf-8"
+	if contentType != "" {
+		if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+			charset = strings.ToLower(params["charset"])
+		}
+	}
+	var text string
+	switch charset {
+	case "utf-8", "us-ascii":
+		text = string(data)
+	case "iso-8859-1", "latin1":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	default:
+		return "", ErrCharset
+	}
+	return strings.ReplaceAll(text, "\r\n", "\n"), nil
+}
@@ -0,0 +1,202 @@
+package mailinglist
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"forumapp/mail"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	// Larger emails are refused, attachments aren't needed
+	maxMessageSize = 1 << 20
+)
+
+var (
+	errAutoReply      = errors.New("ignored automatic reply")
+	errUnknownSender  = errors.New("sender isn't a registered email")
+	errNoReplyAddress = errors.New("no reply address among the recipients")
+	errBadReply       = errors.New("reply address wasn't given to the sender")
+	errNoText         = errors.New("no text/plain part")
+	errEmptyReply     = errors.New("reply is empty")
+	errTooLarge       = errors.New("email is too large")
+)
+
+// Watch turns the answers delivered to the maildir into comments
+// until ctx is cancelled. Handled emails are moved to cur.
+func (l *List) Watch(ctx context.Context, dir string) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0750); err != nil {
+			log.Println("Error: ", err)
+			return
+		}
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		l.poll(dir)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *List) poll(dir string) {
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		log.Println("Error: ", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, "new", entry.Name())
+		if err := l.receive(path); err != nil {
+			log.Printf("Failed to handle email %s: %s\n", entry.Name(), err)
+		}
+		// Refused emails are moved as well, so they're only read once
+		if err := os.Rename(path, filepath.Join(dir, "cur", entry.Name()+":2,S")); err != nil {
+			log.Println("Error: ", err)
+		}
+	}
+}
+
+// receive adds the answer in the email as a reply to the comment
+func (l *List) receive(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	if info, err := f.Stat(); err == nil && info.Size() > maxMessageSize {
+		return errTooLarge
+	}
+
+	msg, err := netmail.ReadMessage(f)
+	if err != nil {
+		return fmt.Errorf("malformed email: %w", err)
+	}
+	// Vacation notices and bounces aren't comments
+	if auto := msg.Header.Get("Auto-Submitted"); auto != "" && !strings.EqualFold(auto, "no") {
+		return errAutoReply
+	}
+	from, err := netmail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return fmt.Errorf("malformed sender: %w", err)
+	}
+	username, ok := l.strg.UserByEmail(from.Address)
+	if !ok {
+		return fmt.Errorf("%s: %w", from.Address, errUnknownSender)
+	}
+	location, err := l.replyLocation(username, msg.Header)
+	if err != nil {
+		return err
+	}
+
+	text, err := textBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return err
+	}
+	text = stripQuote(text)
+	if text == "" {
+		return errEmptyReply
+	}
+
+	_, err = l.strg.Reply(username, text, location)
+	return err
+}
+
+// replyLocation finds the reply address given to the user among
+// the recipients and returns the location it answers
+func (l *List) replyLocation(username string, header netmail.Header) (string, error) {
+	found := false
+	for _, name := range []string{"To", "Cc", "Delivered-To", "X-Original-To"} {
+		addresses, err := header.AddressList(name)
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			location, mac, ok := l.parseReplyAddress(address.Address)
+			if !ok {
+				continue
+			}
+			found = true
+			expected, err := l.sign(username, location)
+			if err != nil {
+				return "", err
+			}
+			if hmac.Equal(mac, expected) {
+				return location, nil
+			}
+		}
+	}
+	if found {
+		return "", errBadReply
+	}
+	return "", errNoReplyAddress
+}
+
+// textBody returns the decoded text/plain part of an email
+func textBody(contentType, transferEncoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if contentType == "" {
+		mediaType, err = "text/plain", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("malformed content type: %w", err)
+	}
+
+	switch {
+	case mediaType == "text/plain":
+		return mail.DecodeBody(contentType, transferEncoding, body)
+	case strings.HasPrefix(mediaType, "multipart/"):
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextPart()
+			if err == io.EOF {
+				return "", errNoText
+			}
+			if err != nil {
+				return "", fmt.Errorf("malformed multipart body: %w", err)
+			}
+			text, err := textBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if !errors.Is(err, errNoText) {
+				return text, err
+			}
+		}
+	default:
+		return "", errNoText
+	}
+}
+
+// stripQuote removes the quoted email and the signature from an answer
+func stripQuote(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if line == "-- " || strings.HasPrefix(trimmed, "-----Original Message-----") {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") ||
+			(strings.HasPrefix(trimmed, "On ") && strings.HasSuffix(trimmed, "wrote:")) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,182 @@
+// Package mailinglist emails new comments to the users that opted in and
+// turns their answers back into comments. Every email has its own reply
+// address, reply+<location>.<mac>@<domain>, signed for the recipient, so
+// an answer is only accepted from the registered email of that user.
+package mailinglist
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"forumapp/events"
+	"forumapp/mail"
+	"forumapp/storage"
+	"forumapp/tmpl"
+	netmail "net/mail"
+	"strings"
+	"text/template"
+)
+
+// Bytes of the HMAC kept in reply addresses
+const macSize = 10
+
+// Lower case base32 survives mail servers that change the case of addresses
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type List struct {
+	strg      *storage.Storage
+	transport mail.Transport
+	from      string
+	// Right side of reply addresses and Message-IDs
+	domain  string
+	baseURL string
+}
+
+func New(strg *storage.Storage, bus *events.Bus, transport mail.Transport, from, baseURL string) *List {
+	l := &List{
+		strg:      strg,
+		transport: transport,
+		from:      from,
+		domain:    "localhost",
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+	}
+	if address, err := netmail.ParseAddress(from); err == nil {
+		if _, domain, ok := strings.Cut(address.Address, "@"); ok {
+			l.domain = domain
+		}
+	}
+	events.SubscribeAsync(bus, l.commentLinked)
+	return l
+}
+
+// sign returns the MAC of a reply address given to the user
+func (l *List) sign(username, location string) ([]byte, error) {
+	key, err := l.strg.MailingListKey()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(username + "\n" + location))
+	return mac.Sum(nil)[:macSize], nil
+}
+
+// replyAddress returns the address the user answers the comment at
+func (l *List) replyAddress(username, location string) (string, error) {
+	mac, err := l.sign(username, location)
+	if err != nil {
+		return "", err
+	}
+	token := encoding.EncodeToString([]byte(location)) + "." + encoding.EncodeToString(mac)
+	return "reply+" + strings.ToLower(token) + "@" + l.domain, nil
+}
+
+// parseReplyAddress returns the location and the MAC of a reply address
+func (l *List) parseReplyAddress(address string) (string, []byte, bool) {
+	at := strings.LastIndex(address, "@")
+	if at < 0 || !strings.EqualFold(address[at+1:], l.domain) {
+		return "", nil, false
+	}
+	token, ok := strings.CutPrefix(strings.ToLower(address[:at]), "reply+")
+	if !ok {
+		return "", nil, false
+	}
+	locationPart, macPart, ok := strings.Cut(strings.ToUpper(token), ".")
+	if !ok {
+		return "", nil, false
+	}
+	location, err := encoding.DecodeString(locationPart)
+	if err != nil {
+		return "", nil, false
+	}
+	mac, err := encoding.DecodeString(macPart)
+	if err != nil {
+		return "", nil, false
+	}
+	return string(location), mac, true
+}
+
+// messageID lets mail clients thread the emails of a post
+func (l *List) messageID(location string) string {
+	user, resource, _ := strings.Cut(strings.TrimPrefix(location, "/"), "/")
+	kind, id, _ := strings.Cut(resource, ":")
+	return "<" + kind + "." + id + "." + encoding.EncodeToString([]byte(user)) + "@" + l.domain + ">"
+}
+
+// commentLinked emails a new comment to the members involved in the thread
+func (l *List) commentLinked(e events.CommentLinked) error {
+	members := l.strg.MailingListMembers(e.Author, e.Parent, e.Post)
+	if len(members) == 0 {
+		return nil
+	}
+	article, err := l.strg.GetNewsArticle(e.Location)
+	if err != nil {
+		return fmt.Errorf("failed to read comment %s: %w", e.Location, err)
+	}
+	t, err := template.ParseFiles("../templates/mailing_list.template")
+	if err != nil {
+		return fmt.Errorf("failed to parse mailing list template: %w", err)
+	}
+
+	var errs []error
+	for _, member := range members {
+		if err := l.send(t, member, article); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (l *List) send(t *template.Template, member storage.MailingListMember, article storage.NewsArticle) error {
+	comment := tmpl.MailingListComment{
+		Username: member.Username,
+		Author:   article.Author,
+		Title:    article.Title,
+		Text:     article.Text,
+		Link:     l.baseURL + "/u" + article.References[0] + "#" + storage.Anchor(article.Location),
+		BaseURL:  l.baseURL,
+	}
+	var subject, body bytes.Buffer
+	if err := t.ExecuteTemplate(&subject, "subject", comment); err != nil {
+		return fmt.Errorf("failed to render mailing list subject: %w", err)
+	}
+	if err := t.ExecuteTemplate(&body, "body", comment); err != nil {
+		return fmt.Errorf("failed to render comment email for %s: %w", member.Username, err)
+	}
+
+	replyTo, err := l.replyAddress(member.Username, article.Location)
+	if err != nil {
+		return err
+	}
+	references := make([]string, 0, len(article.References))
+	for _, location := range article.References {
+		references = append(references, l.messageID(location))
+	}
+	from := l.from
+	if address, err := netmail.ParseAddress(l.from); err == nil {
+		address.Name = article.Author
+		from = address.String()
+	}
+
+	err = l.transport.Send(mail.Message{
+		From:    from,
+		To:      member.Email,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimLeft(body.String(), "\n"),
+		Headers: map[string]string{
+			"Reply-To":         replyTo,
+			"Message-ID":       l.messageID(article.Location),
+			"In-Reply-To":      references[len(references)-1],
+			"References":       strings.Join(references, " "),
+			"List-Id":          "<comments." + l.domain + ">",
+			"List-Unsubscribe": "<" + l.baseURL + "/u/" + member.Username + "?tab=edit>",
+			"Auto-Submitted":   "auto-generated",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to email comment to %s: %w", member.Username, err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package markup
+
+import (
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// highlighter renders fenced code blocks with chroma. Tokens are marked with
+// CSS classes only, the colors live in content/style.css.
+type highlighter struct {
+	formatter *chromahtml.Formatter
+	style     *chroma.Style
+}
+
+func (h *highlighter) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(h, 100),
+	))
+}
+
+func (h *highlighter) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, h.renderFencedCodeBlock)
+}
+
+func (h *highlighter) renderFencedCodeBlock(
+	w util.BufWriter,
+	source []byte,
+	node ast.Node,
+	entering bool,
+) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.FencedCodeBlock)
+
+	var code []byte
+	for i := range n.Lines().Len() {
+		line := n.Lines().At(i)
+		code = append(code, line.Value(source)...)
+	}
+
+	var lexer chroma.Lexer
+	if lang := n.Language(source); lang != nil {
+		lexer = lexers.Get(string(lang))
+	}
+	if lexer != nil {
+		iterator, err := chroma.Coalesce(lexer).Tokenise(nil, string(code))
+		if err == nil && h.formatter.Format(w, h.style, iterator) == nil {
+			return ast.WalkContinue, nil
+		}
+	}
+
+	// Unknown language, render the block as plain text
+	_, _ = w.WriteString(`<pre class="chroma"><code>`)
+	_, _ = w.Write(util.EscapeHTML(code))
+	_, _ = w.WriteString("</code></pre>\n")
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,97 @@
+package markup
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"regexp"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// Only the supported subset of Markdown is parsed. Headings, raw HTML and
+// thematic breaks are left out on purpose, so they end up as plain text.
+var md = goldmark.New(
+	goldmark.WithParser(parser.NewParser(
+		parser.WithBlockParsers(
+			util.Prioritized(parser.NewListParser(), 300),
+			util.Prioritized(parser.NewListItemParser(), 400),
+			util.Prioritized(parser.NewCodeBlockParser(), 500),
+			util.Prioritized(parser.NewFencedCodeBlockParser(), 700),
+			util.Prioritized(parser.NewBlockquoteParser(), 800),
+			util.Prioritized(parser.NewParagraphParser(), 1000),
+		),
+		parser.WithInlineParsers(
+			util.Prioritized(parser.NewCodeSpanParser(), 100),
+			util.Prioritized(parser.NewLinkParser(), 200),
+			util.Prioritized(parser.NewAutoLinkParser(), 300),
+			util.Prioritized(parser.NewEmphasisParser(), 500),
+		),
+		parser.WithParagraphTransformers(parser.DefaultParagraphTransformers()...),
+	)),
+	goldmark.WithExtensions(
+		extension.Linkify,
+		&mathExtension{},
+		&mentionExtension{},
+		&highlighter{
+			formatter: chromahtml.New(chromahtml.WithClasses(true)),
+			style:     styles.Get("monokai"),
+		},
+	),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements(
+		"p", "br", "em", "strong", "ul", "ol", "li",
+		"blockquote", "pre", "code", "span",
+	)
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^[a-z0-9-]+$`)).OnElements("pre", "span")
+
+	// MathML produced by TeXToMathML
+	p.AllowNoAttrs().OnElements(
+		"math", "semantics", "annotation", "mrow", "mi", "mn", "mo", "mtext",
+		"mspace", "msup", "msub", "msubsup", "mfrac", "msqrt", "mroot",
+		"mover", "munder", "munderover", "mtable", "mtr", "mtd",
+	)
+	p.AllowAttrs("display").Matching(regexp.MustCompile(`^(block|inline)$`)).OnElements("math")
+	p.AllowAttrs("encoding").OnElements("annotation")
+	p.AllowAttrs("mathvariant").Matching(bluemonday.SpaceSeparatedTokens).OnElements("mi")
+	p.AllowAttrs("largeop", "movablelimits", "stretchy", "fence", "form").
+		Matching(bluemonday.SpaceSeparatedTokens).OnElements("mo")
+	p.AllowAttrs("accent").Matching(bluemonday.SpaceSeparatedTokens).OnElements("mover")
+	p.AllowAttrs("accentunder").Matching(bluemonday.SpaceSeparatedTokens).OnElements("munder")
+	p.AllowAttrs("linethickness").Matching(bluemonday.Number).OnElements("mfrac")
+	p.AllowAttrs("width").Matching(regexp.MustCompile(`^-?[0-9.]+em$`)).OnElements("mspace")
+	p.AllowAttrs("columnalign").Matching(bluemonday.SpaceSeparatedTokens).OnElements("mtable")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^mention$`)).OnElements("a")
+	p.AllowStandardURLs()
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+// Render converts user supplied Markdown into sanitized HTML
+// that is safe to embed into a template
+func Render(text string) template.HTML {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(text), &buf); err != nil {
+		log.Println("Markdown rendering failed:", err)
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+	return template.HTML(policy.SanitizeBytes(buf.Bytes()))
+}
@@ -0,0 +1,153 @@
+package markup
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"log"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var kindMath = ast.NewNodeKind("Math")
+
+// mathNode holds a $...$ or $$...$$ segment
+type mathNode struct {
+	ast.BaseInline
+	tex     []byte
+	display bool
+}
+
+func (n *mathNode) Kind() ast.NodeKind {
+	return kindMath
+}
+
+func (n *mathNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"TeX":     string(n.tex),
+		"Display": fmt.Sprint(n.display),
+	}, nil)
+}
+
+// mathExtension renders TeX math segments to MathML
+type mathExtension struct{}
+
+func (e *mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(&mathParser{}, 150),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&mathRenderer{}, 100),
+	))
+}
+
+type mathParser struct{}
+
+func (p *mathParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// Parse reads math delimited by `$` or `$$`. Display math may span
+// several lines of a paragraph, inline math has to close on the same line
+// and may not start or end with a space, so prices like $5 stay text.
+func (p *mathParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	delim := []byte("$")
+	if bytes.HasPrefix(line, []byte("$$")) {
+		delim = []byte("$$")
+	}
+	display := len(delim) == 2
+
+	if !display && (len(line) < 2 || util.IsSpace(line[1])) {
+		return nil
+	}
+
+	savedLine, savedPos := block.Position()
+	block.Advance(len(delim))
+
+	var tex []byte
+	for {
+		line, _ := block.PeekLine()
+		if line == nil {
+			break
+		}
+		if end := findMathCloser(line, delim); end >= 0 {
+			tex = append(tex, line[:end]...)
+			block.Advance(end + len(delim))
+			if len(bytes.TrimSpace(tex)) == 0 {
+				break
+			}
+			return &mathNode{tex: tex, display: display}
+		}
+		if !display {
+			break
+		}
+		tex = append(tex, line...)
+		block.AdvanceLine()
+	}
+
+	block.SetPosition(savedLine, savedPos)
+	return nil
+}
+
+// findMathCloser returns the offset of the closing delimiter in line or -1
+func findMathCloser(line, delim []byte) int {
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\':
+			i++
+		case bytes.HasPrefix(line[i:], delim):
+			// A `$` that can't close inline math most likely starts
+			// another amount of money, give up instead of skipping it
+			if len(delim) == 1 && (i == 0 || util.IsSpace(line[i-1]) ||
+				i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9') {
+				return -1
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+type mathRenderer struct{}
+
+func (r *mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMath, r.renderMath)
+}
+
+func (r *mathRenderer) renderMath(
+	w util.BufWriter,
+	source []byte,
+	node ast.Node,
+	entering bool,
+) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	n := node.(*mathNode)
+
+	mathml, err := convertMath(string(n.tex), n.display)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, `<span class="math-error">%s: <code>%s</code></span>`,
+			html.EscapeString(err.Error()), html.EscapeString(string(n.tex)))
+		return ast.WalkSkipChildren, nil
+	}
+	_, _ = w.WriteString(mathml)
+	return ast.WalkSkipChildren, nil
+}
+
+// convertMath never panics, a broken expression must not take the page down
+func convertMath(tex string, display bool) (mathml string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("TeX conversion panicked:", r)
+			err = ErrTeX
+		}
+	}()
+	return TeXToMathML(tex, display)
+}
@@ -0,0 +1,203 @@
+package markup
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTeXToMathML(t *testing.T) {
+	tests := []struct {
+		name    string
+		tex     string
+		display bool
+		want    []string
+		err     error
+	}{
+		{
+			name: "inline",
+			tex:  `x^2`,
+			want: []string{`<math display="inline">`, `<msup><mi>x</mi><mn>2</mn></msup>`},
+		},
+		{
+			name:    "display",
+			tex:     `\frac{a}{b}`,
+			display: true,
+			want:    []string{`<math display="block">`, `<mfrac><mrow><mi>a</mi></mrow><mrow><mi>b</mi></mrow></mfrac>`},
+		},
+		{
+			name: "limits",
+			tex:  `\sum_{i=0}^n i`,
+			want: []string{`<munderover><mo largeop="true" movablelimits="true">∑</mo>`},
+		},
+		{
+			name: "root",
+			tex:  `\sqrt[3]{x}`,
+			want: []string{`<mroot><mrow><mi>x</mi></mrow><mrow><mn>3</mn></mrow></mroot>`},
+		},
+		{
+			name: "variant",
+			tex:  `\mathbb{R}`,
+			want: []string{`<mi>ℝ</mi>`},
+		},
+		{
+			name: "matrix",
+			tex:  `\begin{matrix} a & b \end{matrix}`,
+			want: []string{`<mtr><mtd><mrow><mi>a</mi></mrow></mtd><mtd><mrow><mi>b</mi></mrow></mtd></mtr>`},
+		},
+		{
+			name: "escaped dollar",
+			tex:  `\$`,
+			want: []string{`<mo>$</mo>`},
+		},
+		{
+			name: "less than and ampersand",
+			tex:  `x < y \& z`,
+			want: []string{
+				`<mo>&lt;</mo>`, `<mo>&amp;</mo>`,
+				`<annotation encoding="application/x-tex">x &lt; y \&amp; z</annotation>`,
+			},
+		},
+		{
+			name: "markup in text",
+			tex:  `\text{<b>&</b>}`,
+			want: []string{`<mtext>&lt;b&gt;&amp;&lt;/b&gt;</mtext>`},
+		},
+		{name: "unclosed brace", tex: `{x`, err: ErrTeXUnbalanced},
+		{name: "unopened brace", tex: `x}`, err: ErrTeXUnbalanced},
+		{name: "left without right", tex: `\left( x`, err: ErrTeXUnbalanced},
+		{name: "unclosed environment", tex: `\begin{matrix}a`, err: ErrTeXUnbalanced},
+		{name: "unknown command", tex: `\foo x`, err: ErrTeXUnknownCommand},
+		{name: "unknown environment", tex: `\begin{foo}a\end{foo}`, err: ErrTeXUnknownCommand},
+		{name: "line break outside of environment", tex: `\\`, err: ErrTeXUnknownCommand},
+		{name: "missing fraction argument", tex: `\frac{a}`, err: ErrTeXMissingArg},
+		{name: "missing superscript", tex: `x^`, err: ErrTeXMissingArg},
+		{name: "missing subscript", tex: `x_`, err: ErrTeXMissingArg},
+		{name: "ampersand outside of environment", tex: `\alpha & \beta`, err: ErrTeXUnexpected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TeXToMathML(tt.tex, tt.display)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("TeXToMathML(%q) error = %v, want %v", tt.tex, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TeXToMathML(%q) error = %v", tt.tex, err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("TeXToMathML(%q) = %s\nwant it to contain %s", tt.tex, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderMath(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "inline",
+			text: `Area is $\pi r^2$ here`,
+			want: []string{`<p>Area is <math display="inline">`, `<mi>π</mi>`, `</math> here</p>`},
+		},
+		{
+			name: "display",
+			text: `$$\int_0^1 x\,dx$$`,
+			want: []string{`<math display="block">`, `∫`},
+		},
+		{
+			name: "display over several lines",
+			text: "$$a\nb$$",
+			want: []string{`<math display="block">`, `<mi>a</mi>`, `<mi>b</mi>`},
+		},
+		{
+			name:    "prices",
+			text:    `$5 and $6`,
+			want:    []string{`<p>$5 and $6</p>`},
+			notWant: []string{`<math`},
+		},
+		{
+			name:    "unclosed inline",
+			text:    `$x`,
+			want:    []string{`<p>$x</p>`},
+			notWant: []string{`<math`},
+		},
+		{
+			name:    "unclosed display",
+			text:    `$$x`,
+			want:    []string{`<p>$$x</p>`},
+			notWant: []string{`<math`},
+		},
+		{
+			name:    "empty display",
+			text:    `$$ $$`,
+			notWant: []string{`<math`},
+		},
+		{
+			name:    "markup in math",
+			text:    `$<script>alert(1)</script>$`,
+			want:    []string{`<mo>&lt;</mo><mi>s</mi>`},
+			notWant: []string{`<script>`},
+		},
+		{
+			name: "unbalanced braces",
+			text: `${x$`,
+			want: []string{`<span class="math-error">unbalanced braces: TeX error: <code>{x</code></span>`},
+		},
+		{
+			name: "unknown command",
+			text: `$\foo x$`,
+			want: []string{`<span class="math-error">\foo: unknown command: TeX error: <code>\foo x</code></span>`},
+		},
+		{
+			name:    "escaped error",
+			text:    `$\foo <b> & x$`,
+			want:    []string{`<span class="math-error">`, `<code>\foo &lt;b&gt; &amp; x</code>`},
+			notWant: []string{`<b>`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(Render(tt.text))
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Render(%q) = %s\nwant it to contain %s", tt.text, got, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("Render(%q) = %s\nwant it not to contain %s", tt.text, got, notWant)
+				}
+			}
+		})
+	}
+}
+
+// Every prefix of valid TeX is likely malformed, none of them may panic
+func TestTeXPrefixesDontPanic(t *testing.T) {
+	tex := `\left( \frac{\sqrt[n]{x_1^2}}{\sum_{i=0}^{\infty} \mathbb{R}} \right) ` +
+		`\begin{pmatrix} a & \text{b} \\ c & \hat{d} \end{pmatrix} \int_0^1 f\,dx`
+	if _, err := TeXToMathML(tex, true); err != nil {
+		t.Fatalf("TeXToMathML(%q) error = %v", tex, err)
+	}
+	for i := range len(tex) {
+		_, err := TeXToMathML(tex[:i], false)
+		if err != nil && !errors.Is(err, ErrTeX) {
+			t.Errorf("TeXToMathML(%q) error = %v, want a TeX error", tex[:i], err)
+		}
+		got := string(Render("$" + tex[:i] + "$"))
+		if err != nil && strings.Contains(got, "<math") {
+			t.Errorf("Render of %q shows math despite %v", tex[:i], err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package markup
+
+import (
+	"net/url"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var kindMention = ast.NewNodeKind("Mention")
+
+// mentionNode is an @username reference
+type mentionNode struct {
+	ast.BaseInline
+	username string
+}
+
+func (n *mentionNode) Kind() ast.NodeKind {
+	return kindMention
+}
+
+func (n *mentionNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Username": n.username,
+	}, nil)
+}
+
+// mentionExtension links @username to the user page
+type mentionExtension struct{}
+
+func (e *mentionExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(&mentionParser{}, 600),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&mentionRenderer{}, 100),
+	))
+}
+
+func isUsernameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+type mentionParser struct{}
+
+func (p *mentionParser) Trigger() []byte {
+	return []byte{'@'}
+}
+
+func (p *mentionParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	if pc.IsInLinkLabel() {
+		return nil
+	}
+	// Skip e-mail addresses and the like
+	if prev := block.PrecendingCharacter(); isUsernameRune(prev) {
+		return nil
+	}
+
+	line, _ := block.PeekLine()
+	end := 1
+	for end < len(line) {
+		r, size := utf8.DecodeRune(line[end:])
+		if !isUsernameRune(r) {
+			break
+		}
+		end += size
+	}
+	// A trailing dot ends the sentence, not the name
+	for end > 1 && line[end-1] == '.' {
+		end--
+	}
+	if end == 1 {
+		return nil
+	}
+
+	block.Advance(end)
+	return &mentionNode{username: string(line[1:end])}
+}
+
+type mentionRenderer struct{}
+
+func (r *mentionRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMention, r.renderMention)
+}
+
+func (r *mentionRenderer) renderMention(
+	w util.BufWriter,
+	source []byte,
+	node ast.Node,
+	entering bool,
+) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	n := node.(*mentionNode)
+	_, _ = w.WriteString(`<a class="mention" href="/u/` + url.PathEscape(n.username) + `">@`)
+	_, _ = w.Write(util.EscapeHTML([]byte(n.username)))
+	_, _ = w.WriteString("</a>")
+	return ast.WalkSkipChildren, nil
+}
+
+// Mentions returns the usernames mentioned in text, each one only once.
+// Code spans and blocks are not searched.
+func Mentions(source string) []string {
+	doc := md.Parser().Parse(text.NewReader([]byte(source)))
+
+	var mentions []string
+	seen := make(map[string]bool)
+	_ = ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		n, ok := node.(*mentionNode)
+		if !entering || !ok || seen[n.username] {
+			return ast.WalkContinue, nil
+		}
+		seen[n.username] = true
+		mentions = append(mentions, n.username)
+		return ast.WalkContinue, nil
+	})
+	return mentions
+}
@@ -0,0 +1,670 @@
+package markup
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrTeX               = errors.New("TeX error")
+	ErrTeXUnknownCommand = fmt.Errorf("unknown command: %w", ErrTeX)
+	ErrTeXUnbalanced     = fmt.Errorf("unbalanced braces: %w", ErrTeX)
+	ErrTeXMissingArg     = fmt.Errorf("missing argument: %w", ErrTeX)
+	ErrTeXUnexpected     = fmt.Errorf("unexpected token: %w", ErrTeX)
+)
+
+// Commands rendered as a single identifier
+var texIdentifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ",
+	"varepsilon": "ε", "zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ",
+	"iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ",
+	"pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ", "sigma": "σ",
+	"varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ", "varphi": "φ",
+	"chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ",
+	"Pi": "Π", "Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ",
+	"Omega": "Ω",
+	"infty": "∞", "emptyset": "∅", "varnothing": "∅", "ell": "ℓ",
+	"partial": "∂", "nabla": "∇", "hbar": "ℏ", "aleph": "ℵ", "Re": "ℜ",
+	"Im": "ℑ", "top": "⊤", "bot": "⊥",
+}
+
+// Commands rendered as an operator
+var texOperators = map[string]string{
+	"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅",
+	"cdots": "⋯", "ldots": "…", "dots": "…", "vdots": "⋮", "ddots": "⋱",
+	"ast": "∗", "star": "⋆", "circ": "∘", "bullet": "∙",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠",
+	"ll": "≪", "gg": "≫", "approx": "≈", "equiv": "≡", "sim": "∼",
+	"simeq": "≃", "cong": "≅", "propto": "∝", "prec": "≺", "succ": "≻",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←",
+	"leftrightarrow": "↔", "Rightarrow": "⇒", "Leftarrow": "⇐",
+	"Leftrightarrow": "⇔", "implies": "⟹", "iff": "⟺", "mapsto": "↦",
+	"uparrow": "↑", "downarrow": "↓",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆",
+	"supset": "⊃", "supseteq": "⊇", "cup": "∪", "cap": "∩",
+	"setminus": "∖", "forall": "∀", "exists": "∃", "nexists": "∄",
+	"neg": "¬", "lnot": "¬", "land": "∧", "wedge": "∧", "lor": "∨",
+	"vee": "∨", "oplus": "⊕", "otimes": "⊗", "mid": "∣", "parallel": "∥",
+	"perp": "⊥", "vdash": "⊢", "models": "⊨", "colon": ":",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋",
+	"lceil": "⌈", "rceil": "⌉", "vert": "|", "Vert": "‖",
+	"{": "{", "}": "}", "|": "‖", "bmod": "mod",
+}
+
+// Operators that take limits in display mode
+var texLargeOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "bigcup": "⋃", "bigcap": "⋂",
+	"bigoplus": "⨁", "bigotimes": "⨂", "bigvee": "⋁", "bigwedge": "⋀",
+}
+
+// Integrals keep their scripts to the side
+var texIntegrals = map[string]string{
+	"int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+}
+
+// Named functions written upright
+var texFunctions = []string{
+	"sin", "cos", "tan", "cot", "sec", "csc", "sinh", "cosh", "tanh",
+	"arcsin", "arccos", "arctan", "log", "ln", "lg", "exp", "det", "dim",
+	"gcd", "deg", "arg", "ker", "hom", "Pr",
+}
+
+// Named functions that take limits in display mode
+var texLimitFunctions = []string{
+	"lim", "limsup", "liminf", "max", "min", "sup", "inf", "argmax", "argmin",
+}
+
+var texSpaces = map[string]string{
+	",": "0.1667em", ":": "0.2222em", ";": "0.2778em", " ": "0.25em",
+	"quad": "1em", "qquad": "2em", "!": "-0.1667em",
+}
+
+var texAccents = map[string]string{
+	"hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "vec": "→",
+	"tilde": "~", "widetilde": "~", "dot": "˙", "ddot": "¨",
+	"overrightarrow": "→",
+}
+
+var texVariants = map[string]string{
+	"mathbb": "double-struck", "mathbf": "bold", "mathit": "italic",
+	"mathcal": "script", "mathfrak": "fraktur", "mathsf": "sans-serif",
+	"mathtt": "monospace", "mathrm": "normal", "boldsymbol": "bold",
+}
+
+// Letters with a dedicated code point outside of the
+// Mathematical Alphanumeric Symbols block
+var doubleStruckExceptions = map[rune]rune{
+	'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ',
+}
+
+type texTokenKind int
+
+const (
+	texEOF texTokenKind = iota
+	texCommand
+	texOpenBrace
+	texCloseBrace
+	texSup
+	texSub
+	texAlign
+	texChar
+	texNumber
+)
+
+type texToken struct {
+	kind  texTokenKind
+	value string
+}
+
+type texParser struct {
+	src    string
+	pos    int
+	fences int
+}
+
+// TeXToMathML converts a TeX math expression into a MathML <math> element
+func TeXToMathML(tex string, display bool) (string, error) {
+	p := &texParser{src: tex}
+	body, err := p.parseRow(texEOF)
+	if err != nil {
+		return "", err
+	}
+
+	mode := "inline"
+	if display {
+		mode = "block"
+	}
+	return fmt.Sprintf(
+		`<math display="%s"><semantics><mrow>%s</mrow>`+
+			`<annotation encoding="application/x-tex">%s</annotation></semantics></math>`,
+		mode, body, html.EscapeString(tex),
+	), nil
+}
+
+func (p *texParser) skipSpaces() {
+	for p.pos < len(p.src) {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		p.pos += size
+	}
+}
+
+func (p *texParser) next() texToken {
+	p.skipSpaces()
+	if p.pos >= len(p.src) {
+		return texToken{kind: texEOF}
+	}
+
+	r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	start := p.pos
+	p.pos += size
+
+	switch {
+	case r == '\\':
+		if p.pos >= len(p.src) {
+			return texToken{kind: texCommand, value: ""}
+		}
+		end := p.pos
+		for end < len(p.src) && isASCIILetter(p.src[end]) {
+			end++
+		}
+		if end == p.pos {
+			// Single symbol command like \, or \{
+			_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			end = p.pos + size
+		}
+		name := p.src[p.pos:end]
+		p.pos = end
+		return texToken{kind: texCommand, value: name}
+	case r == '{':
+		return texToken{kind: texOpenBrace, value: "{"}
+	case r == '}':
+		return texToken{kind: texCloseBrace, value: "}"}
+	case r == '^':
+		return texToken{kind: texSup, value: "^"}
+	case r == '_':
+		return texToken{kind: texSub, value: "_"}
+	case r == '&':
+		return texToken{kind: texAlign, value: "&"}
+	case unicode.IsDigit(r) || r == '.' && p.pos < len(p.src) && isASCIIDigit(p.src[p.pos]):
+		for p.pos < len(p.src) && (isASCIIDigit(p.src[p.pos]) ||
+			p.src[p.pos] == '.' && p.pos+1 < len(p.src) && isASCIIDigit(p.src[p.pos+1])) {
+			p.pos++
+		}
+		return texToken{kind: texNumber, value: p.src[start:p.pos]}
+	default:
+		return texToken{kind: texChar, value: string(r)}
+	}
+}
+
+func (p *texParser) peek() texToken {
+	pos := p.pos
+	tok := p.next()
+	p.pos = pos
+	return tok
+}
+
+// parseRow parses atoms until the given closing token
+func (p *texParser) parseRow(until texTokenKind) (string, error) {
+	var b strings.Builder
+	for {
+		tok := p.peek()
+		switch {
+		case tok.kind == until:
+			return b.String(), nil
+		case tok.kind == texEOF:
+			return "", ErrTeXUnbalanced
+		case tok.kind == texCloseBrace:
+			return "", ErrTeXUnbalanced
+		case tok.kind == texCommand && tok.value == "right" && p.fences > 0:
+			return b.String(), nil
+		}
+		atom, err := p.parseScripted()
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(atom)
+	}
+}
+
+// parseScripted parses an atom followed by optional sub and superscripts
+func (p *texParser) parseScripted() (string, error) {
+	tok := p.peek()
+	limits := false
+	base := "<mrow></mrow>"
+	if tok.kind != texSup && tok.kind != texSub {
+		var err error
+		base, limits, err = p.parseAtom()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var sub, sup string
+	for {
+		tok := p.peek()
+		switch {
+		case tok.kind == texSub && sub == "":
+			p.next()
+			arg, err := p.parseArgument()
+			if err != nil {
+				return "", err
+			}
+			sub = arg
+		case tok.kind == texSup && sup == "":
+			p.next()
+			arg, err := p.parseArgument()
+			if err != nil {
+				return "", err
+			}
+			sup = arg
+		case tok.kind == texChar && tok.value == "'" && sup == "":
+			p.next()
+			sup = "<mo>′</mo>"
+			for p.peek().kind == texChar && p.peek().value == "'" {
+				p.next()
+				sup += "<mo>′</mo>"
+			}
+			sup = "<mrow>" + sup + "</mrow>"
+		default:
+			return wrapScripts(base, sub, sup, limits), nil
+		}
+	}
+}
+
+func wrapScripts(base, sub, sup string, limits bool) string {
+	switch {
+	case sub != "" && sup != "" && limits:
+		return "<munderover>" + base + sub + sup + "</munderover>"
+	case sub != "" && sup != "":
+		return "<msubsup>" + base + sub + sup + "</msubsup>"
+	case sub != "" && limits:
+		return "<munder>" + base + sub + "</munder>"
+	case sub != "":
+		return "<msub>" + base + sub + "</msub>"
+	case sup != "" && limits:
+		return "<mover>" + base + sup + "</mover>"
+	case sup != "":
+		return "<msup>" + base + sup + "</msup>"
+	default:
+		return base
+	}
+}
+
+// parseArgument parses a braced group or a single atom
+func (p *texParser) parseArgument() (string, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case texEOF, texCloseBrace, texSup, texSub, texAlign:
+		return "", ErrTeXMissingArg
+	case texOpenBrace:
+		p.next()
+		return p.parseGroup()
+	default:
+		atom, _, err := p.parseAtom()
+		return atom, err
+	}
+}
+
+// parseGroup parses the rest of a group after its opening brace
+func (p *texParser) parseGroup() (string, error) {
+	row, err := p.parseRow(texCloseBrace)
+	if err != nil {
+		return "", err
+	}
+	if p.next().kind != texCloseBrace {
+		return "", ErrTeXUnbalanced
+	}
+	return "<mrow>" + row + "</mrow>", nil
+}
+
+// parseRawArgument returns the unparsed content of a braced group
+func (p *texParser) parseRawArgument() (string, error) {
+	if p.next().kind != texOpenBrace {
+		return "", ErrTeXMissingArg
+	}
+	depth := 1
+	start := p.pos
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				raw := p.src[start:p.pos]
+				p.pos++
+				return raw, nil
+			}
+		}
+		p.pos++
+	}
+	return "", ErrTeXUnbalanced
+}
+
+// parseAtom returns the MathML of a single atom and whether
+// its scripts should be placed as limits
+func (p *texParser) parseAtom() (string, bool, error) {
+	tok := p.next()
+	switch tok.kind {
+	case texOpenBrace:
+		group, err := p.parseGroup()
+		return group, false, err
+	case texNumber:
+		return "<mn>" + tok.value + "</mn>", false, nil
+	case texChar:
+		return charElement(tok.value), false, nil
+	case texCommand:
+		return p.parseCommand(tok.value)
+	case texAlign:
+		return "", false, fmt.Errorf("'&' outside of an environment: %w", ErrTeXUnexpected)
+	case texEOF, texCloseBrace, texSup, texSub:
+		return "", false, fmt.Errorf("'%s': %w", tok.value, ErrTeXUnexpected)
+	}
+	return "", false, ErrTeXUnexpected
+}
+
+func charElement(c string) string {
+	r, _ := utf8.DecodeRuneInString(c)
+	if unicode.IsLetter(r) {
+		return "<mi>" + html.EscapeString(c) + "</mi>"
+	}
+	return "<mo>" + html.EscapeString(c) + "</mo>"
+}
+
+func (p *texParser) parseCommand(name string) (string, bool, error) {
+	if v, ok := texIdentifiers[name]; ok {
+		return "<mi>" + v + "</mi>", false, nil
+	}
+	if v, ok := texOperators[name]; ok {
+		return "<mo>" + html.EscapeString(v) + "</mo>", false, nil
+	}
+	if v, ok := texLargeOperators[name]; ok {
+		return `<mo largeop="true" movablelimits="true">` + v + "</mo>", true, nil
+	}
+	if v, ok := texIntegrals[name]; ok {
+		return `<mo largeop="true">` + v + "</mo>", false, nil
+	}
+	for _, f := range texFunctions {
+		if f == name {
+			return "<mi>" + name + "</mi>", false, nil
+		}
+	}
+	for _, f := range texLimitFunctions {
+		if f == name {
+			return `<mo movablelimits="true" form="prefix">` + name + "</mo>", true, nil
+		}
+	}
+	if v, ok := texSpaces[name]; ok {
+		return `<mspace width="` + v + `"></mspace>`, false, nil
+	}
+	if v, ok := texAccents[name]; ok {
+		arg, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mover accent="true">` + arg + `<mo stretchy="true">` + v + "</mo></mover>", false, nil
+	}
+	if v, ok := texVariants[name]; ok {
+		raw, err := p.parseRawArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return variantElement(raw, v), false, nil
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "binom":
+		num, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		den, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		if name == "binom" {
+			return `<mrow><mo>(</mo><mfrac linethickness="0">` + num + den + `</mfrac><mo>)</mo></mrow>`, false, nil
+		}
+		return "<mfrac>" + num + den + "</mfrac>", false, nil
+	case "sqrt":
+		var index string
+		if p.peek().kind == texChar && p.peek().value == "[" {
+			p.next()
+			var b strings.Builder
+			for {
+				tok := p.peek()
+				if tok.kind == texEOF {
+					return "", false, ErrTeXUnbalanced
+				}
+				if tok.kind == texChar && tok.value == "]" {
+					p.next()
+					break
+				}
+				atom, err := p.parseScripted()
+				if err != nil {
+					return "", false, err
+				}
+				b.WriteString(atom)
+			}
+			index = "<mrow>" + b.String() + "</mrow>"
+		}
+		arg, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		if index != "" {
+			return "<mroot>" + arg + index + "</mroot>", false, nil
+		}
+		return "<msqrt>" + arg + "</msqrt>", false, nil
+	case "underline":
+		arg, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return `<munder accentunder="true">` + arg + `<mo stretchy="true">_</mo></munder>`, false, nil
+	case "text", "textrm", "mbox":
+		raw, err := p.parseRawArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mtext>" + html.EscapeString(raw) + "</mtext>", false, nil
+	case "operatorname":
+		raw, err := p.parseRawArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mi>" + html.EscapeString(raw) + "</mi>", false, nil
+	case "pmod":
+		arg, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mrow><mspace width="1em"></mspace><mo>(</mo><mo>mod</mo>` + arg + "<mo>)</mo></mrow>", false, nil
+	case "left":
+		return p.parseFenced()
+	case "right":
+		return "", false, fmt.Errorf(`\right without \left: %w`, ErrTeXUnexpected)
+	case "begin":
+		return p.parseEnvironment()
+	case "#", "$", "%", "&", "_":
+		return "<mo>" + html.EscapeString(name) + "</mo>", false, nil
+	}
+	return "", false, fmt.Errorf(`\%s: %w`, name, ErrTeXUnknownCommand)
+}
+
+func variantElement(raw, variant string) string {
+	raw = strings.TrimSpace(raw)
+	if variant == "double-struck" {
+		var b strings.Builder
+		for _, r := range raw {
+			switch {
+			case doubleStruckExceptions[r] != 0:
+				b.WriteRune(doubleStruckExceptions[r])
+			case r >= 'A' && r <= 'Z':
+				b.WriteRune(0x1D538 + r - 'A')
+			case r >= 'a' && r <= 'z':
+				b.WriteRune(0x1D552 + r - 'a')
+			default:
+				b.WriteRune(r)
+			}
+		}
+		return "<mi>" + html.EscapeString(b.String()) + "</mi>"
+	}
+	return `<mi mathvariant="` + variant + `">` + html.EscapeString(raw) + "</mi>"
+}
+
+// parseDelimiter reads the delimiter following \left or \right
+func (p *texParser) parseDelimiter() (string, error) {
+	tok := p.next()
+	switch tok.kind {
+	case texChar:
+		if tok.value == "." {
+			return "", nil
+		}
+		return tok.value, nil
+	case texCommand:
+		if v, ok := texOperators[tok.value]; ok {
+			return v, nil
+		}
+	case texEOF, texOpenBrace, texCloseBrace, texSup, texSub, texAlign, texNumber:
+	}
+	return "", fmt.Errorf("missing delimiter: %w", ErrTeXUnexpected)
+}
+
+func (p *texParser) parseFenced() (string, bool, error) {
+	open, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	p.fences++
+	body, err := p.parseRow(texEOF)
+	p.fences--
+	if err != nil {
+		return "", false, err
+	}
+	if tok := p.next(); tok.kind != texCommand || tok.value != "right" {
+		return "", false, fmt.Errorf(`\left without \right: %w`, ErrTeXUnbalanced)
+	}
+	closing, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+
+	var b strings.Builder
+	b.WriteString("<mrow>")
+	if open != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + html.EscapeString(open) + "</mo>")
+	}
+	b.WriteString(body)
+	if closing != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + html.EscapeString(closing) + "</mo>")
+	}
+	b.WriteString("</mrow>")
+	return b.String(), false, nil
+}
+
+// parseEnvironment handles matrix like environments
+func (p *texParser) parseEnvironment() (string, bool, error) {
+	env, err := p.parseRawArgument()
+	if err != nil {
+		return "", false, err
+	}
+
+	var open, closing string
+	switch env {
+	case "matrix", "aligned", "align", "align*", "array":
+	case "pmatrix":
+		open, closing = "(", ")"
+	case "bmatrix":
+		open, closing = "[", "]"
+	case "vmatrix":
+		open, closing = "|", "|"
+	case "cases":
+		open = "{"
+	default:
+		return "", false, fmt.Errorf("environment %s: %w", env, ErrTeXUnknownCommand)
+	}
+	if env == "array" {
+		// Column specification is not used
+		if _, err := p.parseRawArgument(); err != nil {
+			return "", false, err
+		}
+	}
+
+	var table, row, cell strings.Builder
+	endCell := func() {
+		row.WriteString("<mtd><mrow>" + cell.String() + "</mrow></mtd>")
+		cell.Reset()
+	}
+	endRow := func() {
+		endCell()
+		table.WriteString("<mtr>" + row.String() + "</mtr>")
+		row.Reset()
+	}
+
+	for {
+		tok := p.peek()
+		switch {
+		case tok.kind == texEOF:
+			return "", false, fmt.Errorf(`\begin{%s} without \end: %w`, env, ErrTeXUnbalanced)
+		case tok.kind == texAlign:
+			p.next()
+			endCell()
+			continue
+		case tok.kind == texCommand && tok.value == "\\":
+			p.next()
+			endRow()
+			continue
+		case tok.kind == texCommand && tok.value == "end":
+			p.next()
+			name, err := p.parseRawArgument()
+			if err != nil {
+				return "", false, err
+			}
+			if name != env {
+				return "", false, fmt.Errorf(`\begin{%s} ended by \end{%s}: %w`, env, name, ErrTeXUnbalanced)
+			}
+			if cell.Len() > 0 || row.Len() > 0 {
+				endRow()
+			}
+			return fenceTable(table.String(), open, closing), false, nil
+		}
+		atom, err := p.parseScripted()
+		if err != nil {
+			return "", false, err
+		}
+		cell.WriteString(atom)
+	}
+}
+
+func fenceTable(table, open, closing string) string {
+	var b strings.Builder
+	b.WriteString("<mrow>")
+	if open != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + open + "</mo>")
+	}
+	b.WriteString(`<mtable columnalign="left">` + table + "</mtable>")
+	if closing != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + closing + "</mo>")
+	}
+	b.WriteString("</mrow>")
+	return b.String()
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isASCIIDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,190 @@
+package nntp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"forumapp/mail"
+	"forumapp/storage"
+	"mime"
+	netmail "net/mail"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errNotForGroup      = errors.New("not posted to this newsgroup")
+	errUnknownReference = errors.New("references an unknown article")
+	errNoSubject        = errors.New("subject is missing")
+	errLongSubject      = fmt.Errorf("subject is longer than %d bytes", maxSubjectLength)
+	errEmptyBody        = errors.New("body is empty")
+)
+
+// messageID returns the Message-ID of a post or comment,
+// like <post.3.alice@forum.example>
+func (s *Server) messageID(location string) string {
+	user, resource, _ := strings.Cut(strings.TrimPrefix(location, "/"), "/")
+	kind, id, _ := strings.Cut(resource, ":")
+	return "<" + kind + "." + id + "." + url.QueryEscape(user) + "@" + s.host + ">"
+}
+
+// location reverses messageID
+func (s *Server) location(messageID string) (string, bool) {
+	inner, ok := strings.CutPrefix(strings.TrimSpace(messageID), "<")
+	if !ok {
+		return "", false
+	}
+	inner, ok = strings.CutSuffix(inner, ">")
+	at := strings.LastIndex(inner, "@")
+	if !ok || at < 0 || inner[at+1:] != s.host {
+		return "", false
+	}
+	parts := strings.SplitN(inner[:at], ".", 3)
+	if len(parts) != 3 || (parts[0] != "post" && parts[0] != "comment") {
+		return "", false
+	}
+	if _, err := strconv.Atoi(parts[1]); err != nil {
+		return "", false
+	}
+	user, err := url.QueryUnescape(parts[2])
+	if err != nil || user == "" {
+		return "", false
+	}
+	return "/" + user + "/" + parts[0] + ":" + parts[1], true
+}
+
+func (s *Server) subject(article storage.NewsArticle) string {
+	if len(article.References) > 0 {
+		return "Re: " + article.Title
+	}
+	return article.Title
+}
+
+func (s *Server) from(author string) string {
+	address := netmail.Address{Name: author, Address: url.QueryEscape(author) + "@" + s.host}
+	return address.String()
+}
+
+func (s *Server) references(article storage.NewsArticle) string {
+	ids := make([]string, 0, len(article.References))
+	for _, location := range article.References {
+		ids = append(ids, s.messageID(location))
+	}
+	return strings.Join(ids, " ")
+}
+
+// link returns the URL of the post page, scrolled to comments
+func (s *Server) link(article storage.NewsArticle) string {
+	if len(article.References) == 0 {
+		return s.baseURL + "/u" + article.Location
+	}
+	return s.baseURL + "/u" + article.References[0] + "#" + storage.Anchor(article.Location)
+}
+
+// format returns the header and body lines of an article
+func (s *Server) format(article storage.NewsArticle) (headers, body []string) {
+	headers = []string{
+		"Path: " + s.host + "!not-for-mail",
+		"From: " + s.from(article.Author),
+		"Newsgroups: " + s.group,
+		"Subject: " + mime.QEncoding.Encode("utf-8", s.subject(article)),
+		"Date: " + article.CreationDate.Format(time.RFC1123Z),
+		"Message-ID: " + s.messageID(article.Location),
+	}
+	if refs := s.references(article); refs != "" {
+		headers = append(headers, "References: "+refs)
+	}
+	headers = append(headers,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+		"Archived-At: <"+s.link(article)+">",
+	)
+
+	text := strings.ReplaceAll(article.Text, "\r\n", "\n")
+	body = strings.Split(strings.TrimRight(text, "\n"), "\n")
+	return headers, body
+}
+
+// overview returns the OVER line of an article
+func (s *Server) overview(number int, article storage.NewsArticle) string {
+	headers, body := s.format(article)
+	size := len(strings.Join(headers, "\r\n")) + 4 + len(strings.Join(body, "\r\n")) + 2
+	clean := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+	return strings.Join([]string{
+		strconv.Itoa(number),
+		clean.Replace(mime.QEncoding.Encode("utf-8", s.subject(article))),
+		clean.Replace(s.from(article.Author)),
+		article.CreationDate.Format(time.RFC1123Z),
+		s.messageID(article.Location),
+		s.references(article),
+		strconv.Itoa(size),
+		strconv.Itoa(len(body)),
+	}, "\t")
+}
+
+// postArticle stores a posted article as a post, or as a comment
+// when it references one, and returns its location
+func (s *Server) postArticle(username string, data []byte) (string, error) {
+	msg, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("malformed article: %w", err)
+	}
+	groups := strings.Split(msg.Header.Get("Newsgroups"), ",")
+	for i := range groups {
+		groups[i] = strings.TrimSpace(groups[i])
+	}
+	if !slices.Contains(groups, s.group) {
+		return "", errNotForGroup
+	}
+
+	text, err := mail.DecodeBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errEmptyBody
+	}
+
+	// The last reference is the article replied to
+	refs := strings.Fields(msg.Header.Get("References"))
+	if len(refs) == 0 {
+		refs = strings.Fields(msg.Header.Get("In-Reply-To"))
+	}
+	if len(refs) > 0 {
+		return s.postComment(username, refs[len(refs)-1], text)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	subject = strings.TrimSpace(subject)
+	if subject == "" {
+		return "", errNoSubject
+	}
+	if len(subject) > maxSubjectLength {
+		return "", errLongSubject
+	}
+	id, err := s.strg.AddPost(username, subject, text)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("/%s/post:%d", username, id), nil
+}
+
+func (s *Server) postComment(username, reference, text string) (string, error) {
+	parent, ok := s.location(reference)
+	if !ok {
+		return "", errUnknownReference
+	}
+	location, err := s.strg.Reply(username, text, parent)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", errUnknownReference
+	}
+	return location, err
+}
@@ -0,0 +1,401 @@
+package nntp
+
+import (
+	"fmt"
+	"forumapp/storage"
+	"io"
+	"log"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var capabilities = []string{
+	"VERSION 2",
+	"READER",
+	"POST",
+	"AUTHINFO USER",
+	"OVER MSGID",
+	"LIST ACTIVE NEWSGROUPS OVERVIEW.FMT",
+}
+
+var overviewFormat = []string{
+	"Subject:",
+	"From:",
+	"Date:",
+	"Message-ID:",
+	"References:",
+	":bytes",
+	":lines",
+}
+
+// conn is the state of one client connection
+type conn struct {
+	s    *Server
+	text *textproto.Conn
+	// Set after AUTHINFO
+	username    string
+	pendingUser string
+	// Set by GROUP, current is 0 if there is no current article
+	selected bool
+	current  int
+}
+
+func (c *conn) reply(code int, format string, args ...any) error {
+	return c.text.PrintfLine("%d "+format, append([]any{code}, args...)...)
+}
+
+// multiline sends a response followed by a dot-terminated block
+func (c *conn) multiline(code int, status string, lines []string) error {
+	if err := c.reply(code, "%s", status); err != nil {
+		return err
+	}
+	w := c.text.DotWriter()
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			_ = w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// handle runs a command, it reports true when the client quits
+func (c *conn) handle(cmd string, args []string) (bool, error) {
+	switch cmd {
+	case "QUIT":
+		_ = c.reply(205, "Bye")
+		return true, nil
+	case "CAPABILITIES":
+		return false, c.multiline(101, "Capability list follows", capabilities)
+	case "MODE":
+		if len(args) != 1 || !strings.EqualFold(args[0], "READER") {
+			return false, c.reply(501, "Unknown MODE")
+		}
+		return false, c.reply(200, "Posting allowed")
+	case "DATE":
+		return false, c.reply(111, "%s", time.Now().UTC().Format("20060102150405"))
+	case "HELP":
+		return false, c.multiline(100, "Help text follows", []string{
+			"ARTICLE|HEAD|BODY|STAT [number|<message-id>]",
+			"AUTHINFO USER name | AUTHINFO PASS password-or-api-token",
+			"CAPABILITIES", "DATE", "GROUP name", "LAST", "NEXT",
+			"LIST [ACTIVE|NEWSGROUPS|OVERVIEW.FMT]", "LISTGROUP [name [range]]",
+			"MODE READER", "NEWGROUPS date time", "OVER|XOVER [range|<message-id>]",
+			"POST", "QUIT",
+		})
+	case "LIST":
+		return false, c.list(args)
+	case "NEWGROUPS":
+		return false, c.multiline(231, "No new newsgroups", nil)
+	case "GROUP":
+		return false, c.selectGroup(args, false)
+	case "LISTGROUP":
+		return false, c.selectGroup(args, true)
+	case "ARTICLE", "HEAD", "BODY", "STAT":
+		return false, c.article(cmd, args)
+	case "NEXT", "LAST":
+		return false, c.move(cmd)
+	case "OVER", "XOVER":
+		return false, c.over(args)
+	case "AUTHINFO":
+		return false, c.authinfo(args)
+	case "POST":
+		return false, c.post()
+	default:
+		return false, c.reply(500, "Unknown command")
+	}
+}
+
+func (c *conn) list(args []string) error {
+	keyword := "ACTIVE"
+	if len(args) > 0 {
+		keyword = strings.ToUpper(args[0])
+	}
+	// Only the one group, a wildmat may leave it out
+	matches := true
+	if len(args) > 1 {
+		matches, _ = path.Match(args[1], c.s.group)
+	}
+
+	switch keyword {
+	case "ACTIVE":
+		var lines []string
+		if matches {
+			_, first, last := c.s.bounds()
+			lines = append(lines, fmt.Sprintf("%s %d %d y", c.s.group, last, first))
+		}
+		return c.multiline(215, "List of newsgroups follows", lines)
+	case "NEWSGROUPS":
+		var lines []string
+		if matches {
+			lines = append(lines, c.s.group+"\tPosts and comments of "+c.s.baseURL)
+		}
+		return c.multiline(215, "List of newsgroups follows", lines)
+	case "OVERVIEW.FMT":
+		return c.multiline(215, "Order of fields in overview database", overviewFormat)
+	default:
+		return c.reply(501, "Unknown LIST keyword")
+	}
+}
+
+func (c *conn) selectGroup(args []string, listNumbers bool) error {
+	if len(args) == 0 && !(listNumbers && c.selected) {
+		return c.reply(412, "No newsgroup selected")
+	}
+	if len(args) > 0 && args[0] != c.s.group {
+		return c.reply(411, "No such newsgroup")
+	}
+
+	count, first, last := c.s.bounds()
+	c.selected = true
+	c.current = 0
+	if count > 0 {
+		c.current = first
+	}
+	status := fmt.Sprintf("%d %d %d %s", count, first, last, c.s.group)
+	if !listNumbers {
+		return c.reply(211, "%s", status)
+	}
+
+	low, high := first, last
+	if len(args) > 1 {
+		var ok bool
+		if low, high, ok = parseRange(args[1], last); !ok {
+			return c.reply(501, "Invalid range")
+		}
+	}
+	var lines []string
+	for n := max(low, first); n <= min(high, last); n++ {
+		lines = append(lines, strconv.Itoa(n))
+	}
+	return c.multiline(211, status+" list follows", lines)
+}
+
+// parseRange parses n, n- or n-m
+func parseRange(spec string, last int) (int, int, bool) {
+	lowSpec, highSpec, isRange := strings.Cut(spec, "-")
+	low, err := strconv.Atoi(lowSpec)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !isRange {
+		return low, low, true
+	}
+	if highSpec == "" {
+		return low, last, true
+	}
+	high, err := strconv.Atoi(highSpec)
+	if err != nil {
+		return 0, 0, false
+	}
+	return low, high, true
+}
+
+// target finds the article a command is about: by Message-ID,
+// by number in the selected group, or the current article
+func (c *conn) target(args []string) (number int, location string, code int, status string) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "<") {
+		location, ok := c.s.location(args[0])
+		if !ok {
+			return 0, "", 430, "No such article"
+		}
+		if _, err := c.s.strg.GetNewsArticle(location); err != nil {
+			return 0, "", 430, "No such article"
+		}
+		// Articles found by Message-ID don't change the current article
+		number, _ := c.s.numberOf(location)
+		return number, location, 0, ""
+	}
+	if !c.selected {
+		return 0, "", 412, "No newsgroup selected"
+	}
+	if len(args) == 0 {
+		location, ok := c.s.lookup(c.current)
+		if !ok {
+			return 0, "", 420, "Current article number is invalid"
+		}
+		return c.current, location, 0, ""
+	}
+
+	number, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, "", 501, "Invalid article number"
+	}
+	location, ok := c.s.lookup(number)
+	if !ok {
+		return 0, "", 423, "No article with that number"
+	}
+	c.current = number
+	return number, location, 0, ""
+}
+
+func (c *conn) article(cmd string, args []string) error {
+	number, location, code, status := c.target(args)
+	if code != 0 {
+		return c.reply(code, "%s", status)
+	}
+	article, err := c.s.strg.GetNewsArticle(location)
+	if err != nil {
+		return c.reply(430, "No such article")
+	}
+
+	headers, body := c.s.format(article)
+	status = fmt.Sprintf("%d %s", number, c.s.messageID(location))
+	switch cmd {
+	case "ARTICLE":
+		return c.multiline(220, status, append(append(headers, ""), body...))
+	case "HEAD":
+		return c.multiline(221, status, headers)
+	case "BODY":
+		return c.multiline(222, status, body)
+	default:
+		return c.reply(223, "%s", status)
+	}
+}
+
+func (c *conn) move(cmd string) error {
+	if !c.selected {
+		return c.reply(412, "No newsgroup selected")
+	}
+	if _, ok := c.s.lookup(c.current); !ok {
+		return c.reply(420, "Current article number is invalid")
+	}
+	next := c.current + 1
+	if cmd == "LAST" {
+		next = c.current - 1
+	}
+	location, ok := c.s.lookup(next)
+	if !ok {
+		if cmd == "LAST" {
+			return c.reply(422, "No previous article")
+		}
+		return c.reply(421, "No next article")
+	}
+	c.current = next
+	return c.reply(223, "%d %s", next, c.s.messageID(location))
+}
+
+func (c *conn) over(args []string) error {
+	if len(args) > 0 && strings.HasPrefix(args[0], "<") {
+		number, location, code, status := c.target(args)
+		if code != 0 {
+			return c.reply(code, "%s", status)
+		}
+		article, err := c.s.strg.GetNewsArticle(location)
+		if err != nil {
+			return c.reply(430, "No such article")
+		}
+		return c.multiline(224, "Overview information follows", []string{c.s.overview(number, article)})
+	}
+	if !c.selected {
+		return c.reply(412, "No newsgroup selected")
+	}
+
+	low, high := c.current, c.current
+	if len(args) > 0 {
+		_, _, last := c.s.bounds()
+		var ok bool
+		if low, high, ok = parseRange(args[0], last); !ok {
+			return c.reply(501, "Invalid range")
+		}
+	} else if _, ok := c.s.lookup(c.current); !ok {
+		return c.reply(420, "Current article number is invalid")
+	}
+
+	var lines []string
+	_, first, last := c.s.bounds()
+	for n := max(low, first); n <= min(high, last); n++ {
+		location, ok := c.s.lookup(n)
+		if !ok {
+			continue
+		}
+		article, err := c.s.strg.GetNewsArticle(location)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, c.s.overview(n, article))
+	}
+	if len(lines) == 0 {
+		return c.reply(423, "No articles in that range")
+	}
+	return c.multiline(224, "Overview information follows", lines)
+}
+
+func (c *conn) authinfo(args []string) error {
+	if c.username != "" {
+		return c.reply(502, "Already authenticated")
+	}
+	if len(args) < 2 {
+		return c.reply(501, "Syntax error")
+	}
+	switch strings.ToUpper(args[0]) {
+	case "USER":
+		c.pendingUser = args[1]
+		return c.reply(381, "Password required")
+	case "PASS":
+		if c.pendingUser == "" {
+			return c.reply(482, "Authentication commands issued out of sequence")
+		}
+		username := c.pendingUser
+		c.pendingUser = ""
+		if !c.s.authenticate(username, strings.Join(args[1:], " ")) {
+			// Slows down guessing
+			time.Sleep(time.Second)
+			return c.reply(481, "Authentication failed")
+		}
+		c.username = username
+		return c.reply(281, "Authentication accepted")
+	default:
+		return c.reply(501, "Unknown AUTHINFO command")
+	}
+}
+
+// authenticate checks a password, or an API token with the post scope
+func (s *Server) authenticate(username, pass string) bool {
+	if strings.HasPrefix(pass, storage.TokenPrefix) {
+		tokenUser, ok := s.strg.CheckToken(pass, storage.ScopePost)
+		return ok && tokenUser == username
+	}
+	if s.strg.IsRemoteUser(username) {
+		return false
+	}
+	sessionToken, err := s.ses.Auth(username, pass)
+	if err != nil {
+		return false
+	}
+	// Only the password check is needed
+	s.ses.Logout(sessionToken)
+	return true
+}
+
+func (c *conn) post() error {
+	if c.username == "" {
+		return c.reply(480, "Authentication required")
+	}
+	if err := c.reply(340, "Send article to be posted"); err != nil {
+		return err
+	}
+
+	dot := c.text.DotReader()
+	data, err := io.ReadAll(io.LimitReader(dot, maxArticleSize+1))
+	if err != nil {
+		return err
+	}
+	// Keep the connection in sync even if the article is refused
+	if _, err := io.Copy(io.Discard, dot); err != nil {
+		return err
+	}
+	if len(data) > maxArticleSize {
+		return c.reply(441, "Article too large")
+	}
+
+	location, err := c.s.postArticle(c.username, data)
+	if err != nil {
+		log.Println("Error: ", err)
+		return c.reply(441, "Posting failed: %s", strings.ReplaceAll(err.Error(), "\n", " "))
+	}
+	return c.reply(240, "Article received %s", c.s.messageID(location))
+}
@@ -0,0 +1,222 @@
+// Package nntp serves the forum as a newsgroup (RFC 3977). Posts are
+// articles and comments are follow-ups, threaded with References
+// headers that follow the location chain of a comment up to its post.
+// Users post after AUTHINFO with their password or an API token.
+package nntp
+
+import (
+	"context"
+	"errors"
+	"forumapp/events"
+	"forumapp/session"
+	"forumapp/storage"
+	"log"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxConns = 64
+	// Connections without a command for this long are closed
+	idleTimeout = 10 * time.Minute
+	// Size limit of posted articles
+	maxArticleSize = 1 << 20
+	// Subjects become titles of posts, limited like on the forum
+	maxSubjectLength = 200
+)
+
+type Server struct {
+	strg    *storage.Storage
+	ses     *session.Sessions
+	group   string
+	baseURL string
+	// Right side of Message-IDs
+	host string
+
+	mu sync.Mutex
+	// Locations by article number - 1, nil until loaded
+	articles []string
+	numbers  map[string]int
+	conns    map[net.Conn]struct{}
+}
+
+func NewServer(strg *storage.Storage, ses *session.Sessions, bus *events.Bus, group, baseURL string) *Server {
+	s := &Server{
+		strg:    strg,
+		ses:     ses,
+		group:   group,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		host:    "localhost",
+		numbers: map[string]int{},
+		conns:   map[net.Conn]struct{}{},
+	}
+	if u, err := url.Parse(baseURL); err == nil && u.Hostname() != "" {
+		s.host = u.Hostname()
+	}
+	events.SubscribeAsync(bus, s.postCreated)
+	events.SubscribeAsync(bus, s.commentLinked)
+	return s
+}
+
+func (s *Server) postCreated(e events.PostCreated) error {
+	return s.number(e.Location)
+}
+
+func (s *Server) commentLinked(e events.CommentLinked) error {
+	return s.number(e.Location)
+}
+
+// number gives new content the next article number
+func (s *Server) number(location string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Numbered when the articles are loaded
+	if s.articles == nil {
+		return nil
+	}
+	if _, ok := s.numbers[location]; ok {
+		return nil
+	}
+	if err := s.strg.AddNewsArticle(location); err != nil {
+		return err
+	}
+	s.articles = append(s.articles, location)
+	s.numbers[location] = len(s.articles)
+	return nil
+}
+
+func (s *Server) load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	articles, err := s.strg.NewsArticles()
+	if err != nil {
+		return err
+	}
+	s.articles = make([]string, 0, len(articles))
+	for _, location := range articles {
+		if _, ok := s.numbers[location]; ok {
+			continue
+		}
+		s.articles = append(s.articles, location)
+		s.numbers[location] = len(s.articles)
+	}
+	return nil
+}
+
+// bounds returns the number of articles and the first and last number
+func (s *Server) bounds() (count, first, last int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.articles) == 0 {
+		return 0, 1, 0
+	}
+	return len(s.articles), 1, len(s.articles)
+}
+
+// lookup returns the location of an article number
+func (s *Server) lookup(number int) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if number < 1 || number > len(s.articles) {
+		return "", false
+	}
+	return s.articles[number-1], true
+}
+
+// numberOf returns the article number of a location
+func (s *Server) numberOf(location string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	number, ok := s.numbers[location]
+	return number, ok
+}
+
+// ListenAndServe serves NNTP on addr until ctx is cancelled
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Println("NNTP listening on", addr)
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for nc := range s.conns {
+			_ = nc.Close()
+		}
+	}()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if !s.track(nc) {
+			_ = textproto.NewConn(nc).PrintfLine("400 Too many connections")
+			_ = nc.Close()
+			continue
+		}
+		go s.serve(nc)
+	}
+}
+
+// track registers a connection, it reports false when the limit is reached
+func (s *Server) track(nc net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.conns) >= maxConns {
+		return false
+	}
+	s.conns[nc] = struct{}{}
+	return true
+}
+
+func (s *Server) serve(nc net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, nc)
+		s.mu.Unlock()
+		_ = nc.Close()
+	}()
+
+	c := &conn{s: s, text: textproto.NewConn(nc)}
+	_ = nc.SetDeadline(time.Now().Add(idleTimeout))
+	if err := c.text.PrintfLine("200 %s NNTP service ready, posting allowed", s.host); err != nil {
+		return
+	}
+	for {
+		_ = nc.SetDeadline(time.Now().Add(idleTimeout))
+		line, err := c.text.ReadLine()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			_ = c.reply(500, "Empty command")
+			continue
+		}
+		quit, err := c.handle(strings.ToUpper(fields[0]), fields[1:])
+		if quit || err != nil {
+			return
+		}
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"net/http"
 )
 
-func LoginHandler(ses *session.Sessions) http.HandlerFunc {
+func LoginHandler(ses *session.Sessions, strg *storage.Storage) http.HandlerFunc {
 	// Precompute template
 	loginTemplate := template.Must(template.ParseFiles(
 		"../templates/page.template",
@@ -20,9 +20,9 @@ func LoginHandler(ses *session.Sessions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
-			loginAction(loginTemplate, ses, w, r)
+			loginAction(loginTemplate, ses, strg, w, r)
 		case http.MethodGet:
-			loginPage(loginTemplate, ses, w, r, "")
+			loginPage(loginTemplate, ses, strg, w, r, "")
 		default:
 			http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
 				http.StatusMethodNotAllowed)
@@ -33,6 +33,7 @@ func LoginHandler(ses *session.Sessions) http.HandlerFunc {
 func loginPage(
 	t *template.Template,
 	ses *session.Sessions,
+	strg *storage.Storage,
 	w http.ResponseWriter,
 	r *http.Request,
 	status string,
@@ -41,12 +42,9 @@ func loginPage(
 		Content: struct{ LoginStatus string }{status},
 	}
 
-	sessionCookie, err := r.Cookie(session.SessionCookie)
-	if err == nil {
-		page.Username, page.IsLoggedIn = ses.CheckAuth(sessionCookie.Value)
-	}
+	fillPageBase(ses, strg, r, &page)
 
-	err = t.Execute(w, page)
+	err := t.Execute(w, page)
 	if err != nil {
 		log.Println("\"login\" page generation failed:", err)
 	}
@@ -55,6 +53,7 @@ func loginPage(
 func loginAction(
 	t *template.Template,
 	ses *session.Sessions,
+	strg *storage.Storage,
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
@@ -63,7 +62,7 @@ func loginAction(
 
 	sessionToken, err := ses.Auth(username, pass)
 	if err != nil {
-		loginPage(t, ses, w, r, "Invalid credentials")
+		loginPage(t, ses, strg, w, r, "Invalid credentials")
 
 		http.SetCookie(w, &http.Cookie{
 			Name:  session.SessionCookie,
@@ -92,7 +91,7 @@ func RegisterHandler(ses *session.Sessions, strg *storage.Storage) http.HandlerF
 		case http.MethodPost:
 			registerAction(t, ses, strg, w, r)
 		case http.MethodGet:
-			registerPage(t, ses, w, r, "")
+			registerPage(t, ses, strg, w, r, "")
 		default:
 			http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
 				http.StatusMethodNotAllowed)
@@ -103,6 +102,7 @@ func RegisterHandler(ses *session.Sessions, strg *storage.Storage) http.HandlerF
 func registerPage(
 	t *template.Template,
 	ses *session.Sessions,
+	strg *storage.Storage,
 	w http.ResponseWriter,
 	r *http.Request,
 	status string,
@@ -111,12 +111,9 @@ func registerPage(
 		Content: struct{ RegisterStatus string }{status},
 	}
 
-	sessionCookie, err := r.Cookie(session.SessionCookie)
-	if err == nil {
-		page.Username, page.IsLoggedIn = ses.CheckAuth(sessionCookie.Value)
-	}
+	fillPageBase(ses, strg, r, &page)
 
-	err = t.Execute(w, page)
+	err := t.Execute(w, page)
 	if err != nil {
 		log.Println("\"register\" page generation failed:", err)
 	}
@@ -137,16 +134,16 @@ func registerAction(
 
 	switch {
 	case errors.Is(err, storage.ErrInvalidUserData):
-		registerPage(t, ses, w, r, "Invalid registration request")
+		registerPage(t, ses, strg, w, r, "Invalid registration request")
 		return
 	case errors.Is(err, storage.ErrUserExists):
-		registerPage(t, ses, w, r, "Account already exists")
+		registerPage(t, ses, strg, w, r, "Account already exists")
 		return
 	case err != nil:
 		log.Println("Account creation error:", err)
-		registerPage(t, ses, w, r, "An unexpected error has occurred") // should never happen
+		registerPage(t, ses, strg, w, r, "An unexpected error has occurred") // should never happen
 		return
 	}
 
-	registerPage(t, ses, w, r, "success")
+	registerPage(t, ses, strg, w, r, "success")
 }
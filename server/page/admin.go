@@ -0,0 +1,100 @@
+package page
+
+import (
+	"errors"
+	"forumapp/session"
+	"forumapp/storage"
+	"forumapp/tmpl"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// Number of deliveries shown in the log
+const deliveriesCount = 100
+
+func WebhooksHandler(ses *session.Sessions, strg *storage.Storage) http.Handler {
+	return http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		renderWebhooks(ses, strg, "", w, r)
+	})
+}
+
+func renderWebhooks(
+	ses *session.Sessions,
+	strg *storage.Storage,
+	status string,
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	page := tmpl.PageBase[tmpl.WebhooksPage]{
+		PageName: "webhooks",
+	}
+	fillPageBase(ses, strg, r, &page)
+	// Hide the page from everyone else
+	if !page.IsAdmin {
+		NotFoundHandler(w, r)
+		return
+	}
+
+	page.Content = tmpl.WebhooksPage{
+		Status:     status,
+		Events:     storage.Events,
+		Webhooks:   strg.GetWebhooks(),
+		Deliveries: strg.GetDeliveries(deliveriesCount),
+	}
+
+	t := template.Must(template.ParseFiles(
+		"../templates/page.template",
+		"../templates/admin_webhooks.template",
+	))
+	err := t.Execute(w, page)
+	if err != nil {
+		log.Println("\"webhooks\" page generation failed:", err)
+	}
+}
+
+// WebhooksAction adds or deletes webhooks
+func WebhooksAction(ses *session.Sessions, strg *storage.Storage) http.Handler {
+	return http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		sessionCookie, err := r.Cookie(session.SessionCookie)
+		if err != nil {
+			NotFoundHandler(w, r)
+			return
+		}
+		username, isLoggedIn := ses.CheckAuth(sessionCookie.Value)
+		if !isLoggedIn || !strg.IsAdmin(username) {
+			NotFoundHandler(w, r)
+			return
+		}
+
+		switch r.FormValue("action") {
+		case "add":
+			_, err = strg.AddWebhook(r.FormValue("url"), r.Form["event"])
+		case "delete":
+			err = strg.DeleteWebhook(r.FormValue("id"))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("400 bad request"))
+			log.Println("400: Bad Request: Wrong `action` field of incoming request")
+			return
+		}
+
+		switch {
+		case errors.Is(err, storage.ErrInvalidWebhook):
+			renderWebhooks(ses, strg, "The URL must be http or https and at least one event must be selected.", w, r)
+			return
+		case err != nil:
+			log.Println("Error: ", err)
+			renderWebhooks(ses, strg, "Failed to update webhooks.", w, r)
+			return
+		}
+
+		http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+	})
+}
@@ -0,0 +1,34 @@
+package page
+
+import (
+	"forumapp/session"
+	"forumapp/storage"
+	"net/http"
+	"strings"
+)
+
+// authenticate returns the user of the session cookie, or of an API token
+// with the given scope sent as `Authorization: Bearer <token>`
+func authenticate(
+	ses *session.Sessions,
+	strg *storage.Storage,
+	r *http.Request,
+	scope string,
+) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strg.CheckToken(strings.TrimSpace(token), scope)
+	}
+
+	return sessionUser(ses, r)
+}
+
+// sessionUser returns the user of the session cookie only. Account
+// settings can't be changed with API tokens, none of the scopes covers
+// them and a leaked token mustn't be able to take over the account.
+func sessionUser(ses *session.Sessions, r *http.Request) (string, bool) {
+	sessionCookie, err := r.Cookie(session.SessionCookie)
+	if err != nil {
+		return "", false
+	}
+	return ses.CheckAuth(sessionCookie.Value)
+}
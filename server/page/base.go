@@ -0,0 +1,27 @@
+package page
+
+import (
+	"forumapp/session"
+	"forumapp/storage"
+	"forumapp/tmpl"
+	"net/http"
+)
+
+// fillPageBase sets the login related fields shown in page.template
+func fillPageBase[T any](
+	ses *session.Sessions,
+	strg *storage.Storage,
+	r *http.Request,
+	page *tmpl.PageBase[T],
+) {
+	sessionCookie, err := r.Cookie(session.SessionCookie)
+	if err != nil {
+		return
+	}
+	page.Username, page.IsLoggedIn = ses.CheckAuth(sessionCookie.Value)
+	if page.IsLoggedIn {
+		page.UnreadNotifications = strg.UnreadNotifications(page.Username)
+		page.UnreadMessages = strg.UnreadMessages(page.Username)
+		page.IsAdmin = strg.IsAdmin(page.Username)
+	}
+}
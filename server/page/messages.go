@@ -0,0 +1,144 @@
+package page
+
+import (
+	"errors"
+	"forumapp/markup"
+	"forumapp/session"
+	"forumapp/storage"
+	"forumapp/tmpl"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+func MessagesHandler(ses *session.Sessions, strg *storage.Storage) http.Handler {
+	// Precompute template
+	t := template.Must(template.ParseFiles(
+		"../templates/page.template",
+		"../templates/messages.template",
+	))
+
+	return http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		page := tmpl.PageBase[tmpl.MessagesPage]{
+			PageName: "messages",
+		}
+		fillPageBase(ses, strg, r, &page)
+		if !page.IsLoggedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		page.Content.Conversations = strg.GetConversations(page.Username)
+
+		err := t.Execute(w, page)
+		if err != nil {
+			log.Println("\"messages\" page generation failed:", err)
+		}
+	})
+}
+
+func ConversationHandler(ses *session.Sessions, strg *storage.Storage) http.Handler {
+	return http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		renderConversation(ses, strg, r.PathValue("user"), "", w, r)
+	})
+}
+
+func renderConversation(
+	ses *session.Sessions,
+	strg *storage.Storage,
+	other string,
+	status string,
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	page := tmpl.PageBase[tmpl.ConversationPage]{
+		PageName: "messages",
+	}
+	fillPageBase(ses, strg, r, &page)
+	if !page.IsLoggedIn {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// Read before marking, so new messages are still highlighted once
+	page.Content = tmpl.ConversationPage{
+		With:     other,
+		Blocked:  strg.IsBlocked(page.Username, other),
+		Status:   status,
+		Messages: strg.GetConversation(page.Username, other),
+	}
+	if err := strg.MarkConversationRead(page.Username, other); err != nil {
+		log.Println("Failed to mark conversation as read:", err)
+	}
+	page.UnreadMessages = strg.UnreadMessages(page.Username)
+
+	t := template.Must(template.New("").Funcs(template.FuncMap{
+		"markdown": markup.Render,
+	}).ParseFiles(
+		"../templates/page.template",
+		"../templates/conversation.template",
+	))
+
+	err := t.ExecuteTemplate(w, "page.template", page)
+	if err != nil {
+		log.Println("\"conversation\" page generation failed:", err)
+	}
+}
+
+// MessageAction sends a message or toggles blocking of the other user
+func MessageAction(ses *session.Sessions, strg *storage.Storage) http.Handler {
+	return http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		username, isLoggedIn := authenticate(ses, strg, r, storage.ScopePost)
+		if !isLoggedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		var err error
+		other := r.PathValue("user")
+		switch r.FormValue("action") {
+		case "send":
+			err = strg.SendMessage(username, other, r.FormValue("text"))
+		case "block":
+			// Blocking is an account setting, see sessionUser
+			if _, ok := sessionUser(ses, r); !ok {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
+			_, err = strg.ToggleBlock(username, other)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("400 bad request"))
+			log.Println("400: Bad Request: Wrong `action` field of incoming request")
+			return
+		}
+
+		switch {
+		case errors.Is(err, storage.ErrBlocked):
+			renderConversation(ses, strg, other, "You can't message this user.", w, r)
+			return
+		case errors.Is(err, storage.ErrEmptyMessage):
+			renderConversation(ses, strg, other, "Message is empty.", w, r)
+			return
+		case errors.Is(err, storage.ErrNotFound):
+			NotFoundHandler(w, r)
+			return
+		case err != nil:
+			log.Println("Error: ", err)
+			renderConversation(ses, strg, other, "Failed to send the message.", w, r)
+			return
+		}
+
+		http.Redirect(w, r, "/messages/"+url.PathEscape(other), http.StatusSeeOther)
+	})
+}
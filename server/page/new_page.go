@@ -1,6 +1,7 @@
 package page
 
 import (
+	"forumapp/markup"
 	"forumapp/session"
 	"forumapp/storage"
 	"forumapp/tmpl"
@@ -19,7 +20,7 @@ func AddPostHandler(ses *session.Sessions, strg *storage.Storage) http.Handler {
 		case http.MethodPost:
 			addPostAction(ses, strg, w, r)
 		case http.MethodGet:
-			addPostPage(ses, w, r, "", "", "")
+			addPostPage(ses, strg, w, r, "", "", "", "", false)
 		default:
 			http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
 				http.StatusMethodNotAllowed)
@@ -29,43 +30,47 @@ func AddPostHandler(ses *session.Sessions, strg *storage.Storage) http.Handler {
 
 func addPostPage(
 	ses *session.Sessions,
+	strg *storage.Storage,
 	w http.ResponseWriter,
 	r *http.Request,
 	status string,
 	title string,
 	text string,
+	tags string,
+	preview bool,
 ) {
 	page := tmpl.PageBase[struct {
 		AddPostError string
 		Title        string
 		Text         string
+		Tags         string
+		Preview      bool
 	}]{
 		PageName: "addpost",
 		Content: struct {
 			AddPostError string
 			Title        string
 			Text         string
-		}{status, title, text},
+			Tags         string
+			Preview      bool
+		}{status, title, text, tags, preview},
 	}
 
-	sessionCookie, err := r.Cookie(session.SessionCookie)
-	if err != nil {
+	fillPageBase(ses, strg, r, &page)
+	if !page.IsLoggedIn {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
-	page.Username, page.IsLoggedIn = ses.CheckAuth(sessionCookie.Value)
-
-	if !page.IsLoggedIn {
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
-		return
+	fns := template.FuncMap{
+		"markdown": markup.Render,
 	}
 
-	t := template.Must(template.ParseFiles(
+	t := template.Must(template.New("").Funcs(fns).ParseFiles(
 		"../templates/page.template",
 		"../templates/addpost.template",
 	))
-	err = t.Execute(w, page)
+	err := t.ExecuteTemplate(w, "page.template", page)
 	if err != nil {
 		log.Println("\"addPost\" page generation failed:", err)
 	}
@@ -77,42 +82,52 @@ func addPostAction(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	sessionCookie, err := r.Cookie(session.SessionCookie)
-	if err != nil {
-		addPostPage(ses, w, r, "Please log in", "", "")
-		return
-	}
-	username, isLoggedIn := ses.CheckAuth(sessionCookie.Value)
+	username, isLoggedIn := authenticate(ses, strg, r, storage.ScopePost)
 	if !isLoggedIn {
-		addPostPage(ses, w, r, "Please log in", "", "")
+		addPostPage(ses, strg, w, r, "Please log in", "", "", "", false)
 		return
 	}
 
 	title := r.FormValue("title")
 	text := r.FormValue("text")
+	rawTags := r.FormValue("tags")
 
 	if strings.TrimSpace(title) == "" || strings.TrimSpace(text) == "" {
 		log.Println("Error while adding post: title or text are empty")
-		addPostPage(ses,
+		addPostPage(ses, strg,
 			w,
 			r,
 			"Please make sure both the title and text include at least one letter and aren't just empty.",
 			title,
-			text)
+			text,
+			rawTags,
+			false)
 		return
 	}
 
 	if len(title) > 200 {
 		log.Println("Error while adding post: title it too long, max 200 chars")
-		addPostPage(ses, w, r, "Please make sure the title is up to 200 letters.", title, text)
+		addPostPage(ses, strg, w, r, "Please make sure the title is up to 200 letters.", title, text, rawTags, false)
+		return
+	}
+
+	tags, err := storage.ParseTags(rawTags)
+	if err != nil {
+		log.Println("Error while adding post:", err)
+		addPostPage(ses, strg, w, r, "Please use up to 5 tags of letters, digits and dashes, separated by commas.", title, text, rawTags, false)
+		return
+	}
+
+	if r.FormValue("preview") != "" {
+		addPostPage(ses, strg, w, r, "", title, text, rawTags, true)
 		return
 	}
 
-	err = strg.AddPost(username, title, text)
+	_, err = strg.AddPost(username, title, text, tags...)
 
 	if err != nil {
 		log.Println("Error while adding post:", err)
-		addPostPage(ses, w, r, "An unexpected error has occurred", title, text)
+		addPostPage(ses, strg, w, r, "An unexpected error has occurred", title, text, rawTags, false)
 		return
 	}
 
@@ -0,0 +1,97 @@
+package page
+
+import (
+	"forumapp/session"
+	"forumapp/storage"
+	"forumapp/tmpl"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+func NotificationsHandler(ses *session.Sessions, strg *storage.Storage) http.Handler {
+	// Precompute template
+	t := template.Must(template.ParseFiles(
+		"../templates/page.template",
+		"../templates/notifications.template",
+	))
+
+	return http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		page := tmpl.PageBase[tmpl.NotificationsPage]{
+			PageName: "notifications",
+		}
+		fillPageBase(ses, strg, r, &page)
+		if !page.IsLoggedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		page.Content.Notifications = strg.GetNotifications(page.Username)
+
+		err := t.Execute(w, page)
+		if err != nil {
+			log.Println("\"notifications\" page generation failed:", err)
+		}
+	})
+}
+
+// NotificationsAction handles the mark as read buttons of the inbox.
+// Marking as read changes state, tokens need the post scope for it.
+func NotificationsAction(ses *session.Sessions, strg *storage.Storage) http.Handler {
+	return http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		username, isLoggedIn := authenticate(ses, strg, r, storage.ScopePost)
+		if !isLoggedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		var err error
+		switch r.FormValue("action") {
+		case "read":
+			_, err = strg.MarkNotificationRead(username, r.FormValue("id"))
+		case "read_all":
+			err = strg.MarkNotificationsRead(username)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("400 bad request"))
+			log.Println("400: Bad Request: Wrong `action` field of incoming request")
+			return
+		}
+		if err != nil {
+			log.Println("Error: ", err)
+		}
+
+		http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+	})
+}
+
+// OpenNotificationHandler marks the notification as read
+// and redirects to the post or comment it is about, like
+// NotificationsAction tokens need the post scope
+func OpenNotificationHandler(ses *session.Sessions, strg *storage.Storage) http.Handler {
+	return http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		username, isLoggedIn := authenticate(ses, strg, r, storage.ScopePost)
+		if !isLoggedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		notification, err := strg.MarkNotificationRead(username, r.PathValue("id"))
+		if err != nil {
+			log.Println("Error: ", err)
+			NotFoundHandler(w, r)
+			return
+		}
+
+		http.Redirect(w, r, notification.Link, http.StatusSeeOther)
+	})
+}
@@ -0,0 +1,41 @@
+package page
+
+import (
+	"forumapp/session"
+	"forumapp/storage"
+	"forumapp/tmpl"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+func SearchHandler(ses *session.Sessions, strg *storage.Storage) http.Handler {
+	// Precompute template
+	t := template.Must(template.ParseFiles(
+		"../templates/page.template",
+		"../templates/search.template",
+	))
+
+	return http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		query := strings.TrimSpace(r.FormValue("q"))
+
+		page := tmpl.PageBase[tmpl.SearchPage]{
+			PageName: "search",
+			Content: tmpl.SearchPage{
+				Query:   query,
+				Results: strg.Search(query, 50),
+			},
+		}
+
+		fillPageBase(ses, strg, r, &page)
+
+		err := t.Execute(w, page)
+		if err != nil {
+			log.Println("\"search\" page generation failed:", err)
+		}
+	})
+}
@@ -30,12 +30,9 @@ func ActiveHandler(ses *session.Sessions, strg *storage.Storage) http.Handler {
 			Content:  article_list_tmpl,
 		}
 
-		sessionCookie, err := r.Cookie(session.SessionCookie)
-		if err == nil {
-			page.Username, page.IsLoggedIn = ses.CheckAuth(sessionCookie.Value)
-		}
+		fillPageBase(ses, strg, r, &page)
 
-		err = t.Execute(w, page)
+		err := t.Execute(w, page)
 		if err != nil {
 			log.Println("\"active\" page generation failed:", err)
 		}
@@ -2,12 +2,15 @@ package page
 
 import (
 	"fmt"
+	"forumapp/markup"
 	"forumapp/session"
 	"forumapp/storage"
 	"forumapp/tmpl"
 	"html/template"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -25,16 +28,32 @@ func LogoutHandler(ses *session.Sessions) http.Handler {
 	})
 }
 
+// Limits the size of form posts, avatar uploads included
+const maxPostSize = 4 << 20
+
 func UserContentPost(ses *session.Sessions, strg *storage.Storage) http.Handler {
 	return http.HandlerFunc(func(
 		w http.ResponseWriter,
 		r *http.Request,
 	) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxPostSize)
 		switch r.FormValue("type") {
 		case "comment":
 			CommentAction(ses, strg, w, r)
 		case "vote":
 			VoteAction(ses, strg, w, r)
+		case "watch":
+			WatchAction(ses, strg, w, r)
+		case "digest":
+			DigestAction(ses, strg, w, r)
+		case "mailinglist":
+			MailingListAction(ses, strg, w, r)
+		case "profile":
+			ProfileAction(ses, strg, w, r)
+		case "token":
+			TokenAction(ses, strg, w, r)
+		case "fediverse":
+			FediverseAction(ses, strg, w, r)
 		default:
 			w.WriteHeader(http.StatusBadRequest)
 			_, err := w.Write([]byte("400 bad request"))
@@ -62,9 +81,9 @@ func UserContentGet(ses *session.Sessions, strg *storage.Storage) http.Handler {
 			username := strings.FieldsFunc(r.URL.Path, func(c rune) bool {
 				return c == '/'
 			})[0]
-			renderUserPage(ses, strg, username, w, r)
+			renderUserPage(ses, strg, username, r.URL.Query().Get("tab"), "", "", w, r)
 		case storage.POST_RESOURCE:
-			renderPost(ses, strg, r.URL.Path, "", w, r)
+			renderPost(ses, strg, r.URL.Path, "", "", w, r)
 		case storage.COMMENT_RESOURCE:
 			// TODO: render standalone comments with replies (direct link to comment)
 			// renderComment(resourcePath, user, w)
@@ -76,27 +95,53 @@ func UserContentGet(ses *session.Sessions, strg *storage.Storage) http.Handler {
 	})
 }
 
+// Number of comments on the comments tab of the user page
+const userCommentsCount = 50
+
 func renderUserPage(
 	ses *session.Sessions,
 	strg *storage.Storage,
 	username string,
+	tab string,
+	status string,
+	newToken string,
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
 	var page tmpl.UserContentPage[tmpl.UserPage]
+	fillPageBase(ses, strg, r, &page)
 
-	sessionCookie, err := r.Cookie(session.SessionCookie)
-	if err == nil {
-		page.Username, page.IsLoggedIn = ses.CheckAuth(sessionCookie.Value)
+	profile, err := strg.GetProfile(username)
+	if err != nil {
+		NotFoundHandler(w, r)
+		return
 	}
 
 	page.Content = tmpl.UserPage{
 		Username:           username,
 		LogoutButtonActive: page.Username == username,
-		TextPosts:          strg.GetUserArticles(username),
+		ShowMessageButton:  page.IsLoggedIn && page.Username != username,
+		Status:             status,
+		Profile:            profile,
+	}
+	switch {
+	case tab == "comments":
+		page.Content.Comments = strg.GetUserComments(username, userCommentsCount)
+	case tab == "edit" && page.Content.LogoutButtonActive:
+		page.Content.Digest = strg.GetDigest(username)
+		page.Content.MailingList = strg.GetMailingList(username)
+		page.Content.Tokens = strg.GetTokens(username)
+		page.Content.NewToken = newToken
+		page.Content.RemoteFollows = strg.GetRemoteFollows(username)
+	default:
+		tab = "posts"
+		page.Content.TextPosts = strg.GetUserArticles(username)
 	}
+	page.Content.Tab = tab
 
-	t := template.Must(template.New("").ParseFiles(
+	t := template.Must(template.New("").Funcs(template.FuncMap{
+		"markdown": markup.Render,
+	}).ParseFiles(
 		"../templates/user_page.template",
 		"../templates/article_list.template",
 		"../templates/page.template",
@@ -113,21 +158,53 @@ func renderPost(
 	strg *storage.Storage,
 	uri string,
 	status string,
+	draft string,
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	var page tmpl.UserContentPage[tmpl.TextPost]
+	renderThread(ses, strg, uri, status, draft, "", w, r)
+}
 
-	sessionCookie, err := r.Cookie(session.SessionCookie)
-	if err == nil {
-		page.Username, page.IsLoggedIn = ses.CheckAuth(sessionCookie.Value)
-	}
+// renderThread renders the post like renderPost, with the draft in the
+// reply form of the comment at replyTo instead of the comment form
+// when replyTo isn't empty
+func renderThread(
+	ses *session.Sessions,
+	strg *storage.Storage,
+	uri string,
+	status string,
+	draft string,
+	replyTo string,
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var page tmpl.UserContentPage[tmpl.TextPost]
+	fillPageBase(ses, strg, r, &page)
 
 	content, err := strg.GetPost(uri)
 	if err != nil {
 		log.Println("\"post\" page generation failed:", err)
 	}
 	content.TextPostError = status
+	if replyTo == "" {
+		content.CommentDraft = draft
+	} else {
+		showReplyForm(content.Comments, replyTo, draft)
+	}
+	if page.IsLoggedIn {
+		content.UserVote, _ = strg.CheckVote(page.Username, uri)
+		markUserVotes(strg, page.Username, content.Comments)
+	}
+	if r.URL.Query().Get("sort") == "score" {
+		content.SortByScore = true
+		sortCommentsByScore(content.Comments)
+	}
+	if page.IsLoggedIn && strg.IsWatching(page.Username, uri) {
+		content.Watching = true
+		if err := strg.MarkThreadVisited(page.Username, uri); err != nil {
+			log.Println("Failed to update watched thread:", err)
+		}
+	}
 	page.Content = content
 
 	fns := template.FuncMap{
@@ -137,6 +214,7 @@ func renderPost(
 			}
 			return comments
 		},
+		"markdown": markup.Render,
 	}
 
 	t := template.Must(template.New("").Funcs(fns).ParseFiles(
@@ -152,89 +230,267 @@ func renderPost(
 	}
 }
 
+// showReplyForm opens the reply form of the comment at location
+// with the draft in it, it reports whether the comment was found
+func showReplyForm(comments []tmpl.Comment, location, draft string) bool {
+	for i := range comments {
+		if comments[i].UserLocation == location {
+			comments[i].ShowReplyForm = true
+			comments[i].ReplyDraft = draft
+			return true
+		}
+		if showReplyForm(comments[i].Replies, location, draft) {
+			return true
+		}
+	}
+	return false
+}
+
+// markUserVotes sets the vote of the user on every comment
+func markUserVotes(strg *storage.Storage, username string, comments []tmpl.Comment) {
+	for i := range comments {
+		comments[i].UserVote, _ = strg.CheckVote(username, comments[i].UserLocation)
+		markUserVotes(strg, username, comments[i].Replies)
+	}
+}
+
+// sortCommentsByScore orders comments and their replies by votes,
+// keeping the original order between equal scores
+func sortCommentsByScore(comments []tmpl.Comment) {
+	slices.SortStableFunc(comments, func(a, b tmpl.Comment) int {
+		votesA, _ := strconv.Atoi(strings.TrimSpace(a.Votes))
+		votesB, _ := strconv.Atoi(strings.TrimSpace(b.Votes))
+		return votesB - votesA
+	})
+	for i := range comments {
+		sortCommentsByScore(comments[i].Replies)
+	}
+}
+
 func VoteAction(ses *session.Sessions, strg *storage.Storage, w http.ResponseWriter, r *http.Request) {
 	location := r.FormValue("location")
 	vote_type := r.FormValue("vote_type")
 
-	sessionCookie, err := r.Cookie(session.SessionCookie)
+	// Votes on comments show errors on the post page
+	postLocation, err := strg.RootPost(location)
 	if err != nil {
-		log.Println("Error: auth failed")
-		renderPost(ses, strg, location, "auth failed", w, r)
+		log.Println("Error: ", err)
+		NotFoundHandler(w, r)
 		return
 	}
-	username, isLoggedIn := ses.CheckAuth(sessionCookie.Value)
+
+	username, isLoggedIn := authenticate(ses, strg, r, storage.ScopeVote)
 	if !isLoggedIn {
 		log.Println("Error: not logged in")
-		renderPost(ses, strg, location, "not logged in", w, r)
+		renderPost(ses, strg, postLocation, "not logged in", "", w, r)
 		return
 	}
-	if vote_type != "+" && vote_type != "-" {
+	if vote_type != "+" && vote_type != "-" && vote_type != "0" {
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = w.Write([]byte("400 bad request"))
 		log.Println("400: Bad Request: Wrong `vote_type` of incoming request")
 		return
 	}
 
-	saved_vote, err := strg.CheckVote(username, location)
-	if err != nil {
+	if _, _, err := strg.ToggleVote(username, vote_type, location); err != nil {
 		log.Println("Error: ", err)
+		renderPost(ses, strg, postLocation, fmt.Sprint("Error: ", err), "", w, r)
 		return
 	}
 
-	if saved_vote == vote_type {
-		http.Redirect(w, r, r.Header.Get("Referer"), http.StatusFound)
+	// Fallback for clients without JavaScript, vote.js uses VoteJSONHandler
+	http.Redirect(w, r, r.Header.Get("Referer"), http.StatusFound)
+}
+
+func WatchAction(ses *session.Sessions, strg *storage.Storage, w http.ResponseWriter, r *http.Request) {
+	location := r.FormValue("location")
+
+	username, isLoggedIn := authenticate(ses, strg, r, storage.ScopePost)
+	if !isLoggedIn {
+		log.Println("Error: not logged in")
+		renderPost(ses, strg, location, "not logged in", "", w, r)
 		return
 	}
 
-	update_amount := "2"
-	if saved_vote == "0" { // If the user is voting for the first time cache has to only update by 1
-		update_amount = "1"
+	if _, err := strg.ToggleWatch(username, location); err != nil {
+		log.Println("Error: ", err)
+		renderPost(ses, strg, location, fmt.Sprint("Error: ", err), "", w, r)
+		return
 	}
 
-	if strg.UpdateVoteCache(vote_type+update_amount, location) == nil &&
-		strg.AddVote(username, vote_type, location) == nil {
-		http.Redirect(w, r, r.Header.Get("Referer"), http.StatusFound)
+	http.Redirect(w, r, r.Header.Get("Referer"), http.StatusFound)
+}
+
+// DigestAction changes the digest email settings of the logged in user
+func DigestAction(ses *session.Sessions, strg *storage.Storage, w http.ResponseWriter, r *http.Request) {
+	username, isLoggedIn := sessionUser(ses, r)
+	if !isLoggedIn {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
-	//TODO: remove the redirect and make some css magic to show the upvote number changing client side
-	http.Redirect(w, r, r.Header.Get("Referer"), http.StatusFound)
+	if err := strg.SetDigest(username, r.FormValue("digest")); err != nil {
+		log.Println("Error: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("400 bad request"))
+		return
+	}
+
+	http.Redirect(w, r, "/u/"+username+"?tab=edit", http.StatusSeeOther)
 }
 
-func CommentAction(ses *session.Sessions, strg *storage.Storage, w http.ResponseWriter, r *http.Request) {
-	location := r.FormValue("location")
-	text := r.FormValue("comment")
-	sessionCookie, err := r.Cookie(session.SessionCookie)
+// MailingListAction turns comment emails of the logged in user on or off
+func MailingListAction(ses *session.Sessions, strg *storage.Storage, w http.ResponseWriter, r *http.Request) {
+	username, isLoggedIn := sessionUser(ses, r)
+	if !isLoggedIn {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if err := strg.SetMailingList(username, r.FormValue("mailing_list") != ""); err != nil {
+		log.Println("Error: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("400 bad request"))
+		return
+	}
+
+	http.Redirect(w, r, "/u/"+username+"?tab=edit", http.StatusSeeOther)
+}
+
+// ProfileAction updates the profile of the logged in user
+func ProfileAction(ses *session.Sessions, strg *storage.Storage, w http.ResponseWriter, r *http.Request) {
+	username, isLoggedIn := sessionUser(ses, r)
+	if !isLoggedIn {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	err := strg.UpdateProfile(username, r.FormValue("display_name"), r.FormValue("bio"), r.FormValue("website"))
+	if err != nil {
+		log.Println("Error: ", err)
+		renderUserPage(ses, strg, username, "edit", fmt.Sprint(err), "", w, r)
+		return
+	}
+
+	avatar, _, err := r.FormFile("avatar")
+	if err == nil {
+		defer func() { _ = avatar.Close() }()
+		if err := strg.SetAvatar(username, avatar); err != nil {
+			log.Println("Error: ", err)
+			renderUserPage(ses, strg, username, "edit", fmt.Sprint(err), "", w, r)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/u/"+username, http.StatusSeeOther)
+}
+
+// TokenAction creates or revokes API tokens of the logged in user.
+// Like the other account settings it only takes a session, not a token.
+func TokenAction(ses *session.Sessions, strg *storage.Storage, w http.ResponseWriter, r *http.Request) {
+	username, isLoggedIn := sessionUser(ses, r)
+	if !isLoggedIn {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	var err error
+	switch r.FormValue("action") {
+	case "create":
+		token, err := strg.CreateToken(username, r.FormValue("name"), r.Form["scope"])
+		if err != nil {
+			log.Println("Error: ", err)
+			renderUserPage(ses, strg, username, "edit", fmt.Sprint(err), "", w, r)
+			return
+		}
+		renderUserPage(ses, strg, username, "edit", "", token, w, r)
+		return
+	case "revoke":
+		err = strg.RevokeToken(username, r.FormValue("id"))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("400 bad request"))
+		log.Println("400: Bad Request: Wrong `action` field of incoming request")
+		return
+	}
+	if err != nil {
+		log.Println("Error: ", err)
+		renderUserPage(ses, strg, username, "edit", fmt.Sprint(err), "", w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/u/"+username+"?tab=edit", http.StatusSeeOther)
+}
+
+// FediverseAction follows or unfollows an account of another server
+// for the logged in user, the follow is sent in the background
+func FediverseAction(ses *session.Sessions, strg *storage.Storage, w http.ResponseWriter, r *http.Request) {
+	username, isLoggedIn := sessionUser(ses, r)
+	if !isLoggedIn {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
 
+	var err error
+	switch r.FormValue("action") {
+	case "follow":
+		err = strg.FollowRemote(username, r.FormValue("handle"))
+	case "unfollow":
+		err = strg.UnfollowRemote(username, r.FormValue("handle"))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("400 bad request"))
+		log.Println("400: Bad Request: Wrong `action` field of incoming request")
+		return
+	}
 	if err != nil {
-		log.Println("Error: auth failed")
-		renderPost(ses, strg, location, "auth failed", w, r)
+		log.Println("Error: ", err)
+		renderUserPage(ses, strg, username, "edit", fmt.Sprint(err), "", w, r)
 		return
 	}
-	username, isLoggedIn := ses.CheckAuth(sessionCookie.Value)
+
+	http.Redirect(w, r, "/u/"+username+"?tab=edit", http.StatusSeeOther)
+}
+
+// AvatarHandler serves the avatar image of a user
+func AvatarHandler(strg *storage.Storage) http.Handler {
+	return http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		p, err := strg.AvatarPath(r.PathValue("user"))
+		if err != nil {
+			NotFoundHandler(w, r)
+			return
+		}
+		http.ServeFile(w, r, p)
+	})
+}
+
+func CommentAction(ses *session.Sessions, strg *storage.Storage, w http.ResponseWriter, r *http.Request) {
+	location := r.FormValue("location")
+	text := r.FormValue("comment")
+	username, isLoggedIn := authenticate(ses, strg, r, storage.ScopePost)
 	if !isLoggedIn {
 		log.Println("Error: not logged in")
-		renderPost(ses, strg, location, "not logged in", w, r)
+		renderPost(ses, strg, location, "not logged in", "", w, r)
 		return
 	}
 
 	if strings.TrimSpace(text) == "" {
 		log.Println("Error while adding comment: text is empty")
-		renderPost(ses, strg, location, "Please make sure the text include at least one letter and isn't just empty.", w, r)
+		renderPost(ses, strg, location, "Please make sure the text include at least one letter and isn't just empty.", "", w, r)
 		return
 	}
 
-	id, err := strg.AddComment(username, text, location)
-	if err != nil {
-		log.Println("Error: ", err)
-		renderPost(ses, strg, location, fmt.Sprint("Error: ", err), w, r)
+	if r.FormValue("preview") != "" {
+		renderPost(ses, strg, location, "", text, w, r)
 		return
 	}
 
-	err = strg.AddCommentRef(username, location, location, "comments", id)
-	if err != nil {
+	if _, err := strg.Reply(username, text, location); err != nil {
 		log.Println("Error: ", err)
-		renderPost(ses, strg, location, fmt.Sprint("Error: ", err), w, r)
+		renderPost(ses, strg, location, fmt.Sprint("Error: ", err), "", w, r)
 		return
 	}
 
@@ -246,40 +502,38 @@ func ReplyAction(ses *session.Sessions, strg *storage.Storage) http.Handler {
 		w http.ResponseWriter,
 		r *http.Request,
 	) {
-		location := r.FormValue("location")
 		user_location := r.FormValue("user_location")
 		text := r.FormValue("comment")
 
-		sessionCookie, err := r.Cookie(session.SessionCookie)
+		// Replies to replies have a comment as parent, the page is of the post
+		location, err := strg.RootPost(user_location)
 		if err != nil {
-			log.Println("Error: auth failed")
-			renderPost(ses, strg, location, "auth failed", w, r)
+			log.Println("Error: ", err)
+			NotFoundHandler(w, r)
 			return
 		}
-		username, isLoggedIn := ses.CheckAuth(sessionCookie.Value)
+
+		username, isLoggedIn := authenticate(ses, strg, r, storage.ScopePost)
 		if !isLoggedIn {
 			log.Println("Error: not logged in")
-			renderPost(ses, strg, location, "not logged in", w, r)
+			renderPost(ses, strg, location, "not logged in", "", w, r)
 			return
 		}
 
 		if strings.TrimSpace(text) == "" {
 			log.Println("Error while adding comment: text is empty")
-			renderPost(ses, strg, location, "Please make sure the text include at least one letter and isn't just empty.", w, r)
+			renderPost(ses, strg, location, "Please make sure the text include at least one letter and isn't just empty.", "", w, r)
 			return
 		}
 
-		id, err := strg.AddComment(username, text, user_location)
-		if err != nil {
-			log.Println("Error: ", err)
-			renderPost(ses, strg, location, fmt.Sprint("Error: ", err), w, r)
+		if r.FormValue("preview") != "" {
+			renderThread(ses, strg, location, "", text, user_location, w, r)
 			return
 		}
 
-		err = strg.AddCommentRef(username, user_location, location, "replies", id)
-		if err != nil {
+		if _, err := strg.Reply(username, text, user_location); err != nil {
 			log.Println("Error: ", err)
-			renderPost(ses, strg, location, fmt.Sprint("Error: ", err), w, r)
+			renderPost(ses, strg, location, fmt.Sprint("Error: ", err), "", w, r)
 			return
 		}
 
@@ -0,0 +1,61 @@
+package page
+
+import (
+	"encoding/json"
+	"errors"
+	"forumapp/session"
+	"forumapp/storage"
+	"log"
+	"net/http"
+)
+
+type voteResponse struct {
+	Score int    `json:"score"`
+	Vote  string `json:"vote"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Failed to write JSON response:", err)
+	}
+}
+
+// VoteJSONHandler votes like VoteAction, but answers with the new
+// score and the vote of the user instead of redirecting
+func VoteJSONHandler(ses *session.Sessions, strg *storage.Storage) http.Handler {
+	return http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		username, isLoggedIn := authenticate(ses, strg, r, storage.ScopeVote)
+		if !isLoggedIn {
+			writeJSON(w, http.StatusUnauthorized, errorResponse{"not logged in"})
+			return
+		}
+
+		vote := r.FormValue("vote_type")
+		if vote != "+" && vote != "-" && vote != "0" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{storage.ErrInvalidVote.Error()})
+			return
+		}
+
+		score, vote, err := strg.ToggleVote(username, vote, r.FormValue("location"))
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidURI):
+			writeJSON(w, http.StatusNotFound, errorResponse{"not found"})
+			return
+		case err != nil:
+			log.Println("Error: ", err)
+			writeJSON(w, http.StatusInternalServerError, errorResponse{"failed to vote"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, voteResponse{Score: score, Vote: vote})
+	})
+}
@@ -0,0 +1,39 @@
+package page
+
+import (
+	"forumapp/session"
+	"forumapp/storage"
+	"forumapp/tmpl"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+func WatchingHandler(ses *session.Sessions, strg *storage.Storage) http.Handler {
+	// Precompute template
+	t := template.Must(template.ParseFiles(
+		"../templates/page.template",
+		"../templates/watching.template",
+	))
+
+	return http.HandlerFunc(func(
+		w http.ResponseWriter,
+		r *http.Request,
+	) {
+		page := tmpl.PageBase[tmpl.WatchingPage]{
+			PageName: "watching",
+		}
+		fillPageBase(ses, strg, r, &page)
+		if !page.IsLoggedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		page.Content.Threads = strg.GetWatchedThreads(page.Username)
+
+		err := t.Execute(w, page)
+		if err != nil {
+			log.Println("\"watching\" page generation failed:", err)
+		}
+	})
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"forumapp/activitypub"
+	"forumapp/api"
+	"forumapp/feed"
+	"forumapp/live"
 	"forumapp/page"
 	"forumapp/session"
 	"forumapp/storage"
@@ -25,21 +29,42 @@ func ServeFile(name string) http.Handler {
 	})
 }
 
-func addRoutes(mux *http.ServeMux, sessions *session.Sessions, strg *storage.Storage) {
+func addRoutes(mux *http.ServeMux, cfg Config, sessions *session.Sessions, strg *storage.Storage, hub *live.Hub, fed *activitypub.Federation) {
 	// Static content
 	fs := http.FileServer(http.Dir("../content/"))
 	mux.Handle("GET /content/", http.StripPrefix("/content", FileServerFilter(fs)))
 	mux.Handle("GET /favicon.ico", ServeFile("../content/favicon.ico"))
-	mux.Handle("GET /feed", ServeFile("../storage/feed"))
 
 	// Dynamic content
 	mux.Handle("/", page.MainPageHandler())
 	mux.Handle("GET /active", page.ActiveHandler(sessions, strg))
-	mux.Handle("GET /u/", http.StripPrefix("/u", page.UserContentGet(sessions, strg)))
+	mux.Handle("GET /search", page.SearchHandler(sessions, strg))
+	mux.Handle("GET /notifications", page.NotificationsHandler(sessions, strg))
+	mux.Handle("POST /notifications", page.NotificationsAction(sessions, strg))
+	mux.Handle("GET /notifications/{id}", page.OpenNotificationHandler(sessions, strg))
+	mux.Handle("GET /watching", page.WatchingHandler(sessions, strg))
+	mux.Handle("GET /messages", page.MessagesHandler(sessions, strg))
+	mux.Handle("GET /messages/{user}", page.ConversationHandler(sessions, strg))
+	mux.Handle("POST /messages/{user}", page.MessageAction(sessions, strg))
+	mux.Handle("GET /avatar/{user}", page.AvatarHandler(strg))
+	mux.Handle("GET /u/", fed.Negotiate(http.StripPrefix("/u", page.UserContentGet(sessions, strg))))
 	mux.Handle("POST /u/", http.StripPrefix("/u", page.UserContentPost(sessions, strg)))
 	mux.Handle("GET /logout", page.LogoutHandler(sessions))
 	mux.Handle("POST /reply", page.ReplyAction(sessions, strg))
-	mux.Handle("/login", page.LoginHandler(sessions))
+	mux.Handle("POST /vote", page.VoteJSONHandler(sessions, strg))
+	mux.Handle("GET /live/{user}/{post}", hub)
+	mux.Handle("GET /admin/webhooks", page.WebhooksHandler(sessions, strg))
+	mux.Handle("POST /admin/webhooks", page.WebhooksAction(sessions, strg))
+	mux.Handle("/login", page.LoginHandler(sessions, strg))
 	mux.Handle("/register", page.RegisterHandler(sessions, strg))
 	mux.Handle("/addpost", page.AddPostHandler(sessions, strg))
+
+	// JSON API
+	api.New(sessions, strg).Register(mux)
+
+	// Atom and JSON feeds
+	feed.New(strg, cfg.BaseURL).Register(mux)
+
+	// ActivityPub federation
+	fed.Register(mux)
 }
@@ -0,0 +1,181 @@
+package search
+
+import (
+	"html/template"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	dateLayout   = "2006-01-02"
+	snippetWords = 30
+)
+
+// Query is a parsed search query. Supported syntax:
+//
+//	word            documents containing the word
+//	"some phrase"   documents containing the exact phrase
+//	author:name     documents written by the user
+//	after:YYYY-MM-DD, before:YYYY-MM-DD
+//	                documents created within the date range
+type Query struct {
+	Terms   []string
+	Phrases [][]string
+	Author  string
+	After   time.Time
+	Before  time.Time
+}
+
+func ParseQuery(raw string) Query {
+	var q Query
+	for _, field := range splitQuery(raw) {
+		if strings.HasPrefix(field, `"`) {
+			words, _ := tokenize(field)
+			switch len(words) {
+			case 0:
+			case 1:
+				q.Terms = append(q.Terms, words[0])
+			default:
+				q.Phrases = append(q.Phrases, words)
+			}
+			continue
+		}
+
+		key, value, found := strings.Cut(field, ":")
+		if found && value != "" {
+			switch strings.ToLower(key) {
+			case "author":
+				q.Author = value
+				continue
+			case "after":
+				if t, err := time.Parse(dateLayout, value); err == nil {
+					q.After = t
+					continue
+				}
+			case "before":
+				if t, err := time.Parse(dateLayout, value); err == nil {
+					// Include the whole day
+					q.Before = t.AddDate(0, 0, 1)
+					continue
+				}
+			}
+		}
+
+		words, _ := tokenize(field)
+		q.Terms = append(q.Terms, words...)
+	}
+	return q
+}
+
+// splitQuery splits on whitespace, keeping quoted phrases in one piece
+func splitQuery(raw string) []string {
+	var fields []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range raw {
+		switch {
+		case r == '"':
+			if quoted {
+				current.WriteRune(r)
+				fields = append(fields, current.String())
+				current.Reset()
+			} else {
+				if current.Len() > 0 {
+					fields = append(fields, current.String())
+					current.Reset()
+				}
+				current.WriteRune(r)
+			}
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+func (q Query) IsEmpty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0 &&
+		q.Author == "" && q.After.IsZero() && q.Before.IsZero()
+}
+
+// words returns all terms including the ones inside phrases
+func (q Query) words() []string {
+	words := slices.Clone(q.Terms)
+	for _, phrase := range q.Phrases {
+		words = append(words, phrase...)
+	}
+	return words
+}
+
+func (q Query) matchesFilters(doc Document) bool {
+	if q.Author != "" && !strings.EqualFold(q.Author, doc.Author) {
+		return false
+	}
+	if !q.After.IsZero() && doc.Date.Before(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !doc.Date.Before(q.Before) {
+		return false
+	}
+	return true
+}
+
+// snippet returns an excerpt of text around the first matched word
+// with all matched words wrapped in <mark>
+func snippet(text string, words []string) template.HTML {
+	tokens, offsets := tokenize(text)
+	if len(tokens) == 0 {
+		return ""
+	}
+
+	first := 0
+	for i, t := range tokens {
+		if slices.Contains(words, t) {
+			first = i
+			break
+		}
+	}
+	start := max(0, first-snippetWords/3)
+	end := min(len(tokens), start+snippetWords)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("… ")
+	}
+	pos := offsets[start]
+	for i := start; i < end; i++ {
+		b.WriteString(template.HTMLEscapeString(text[pos:offsets[i]]))
+		word := wordAt(text, offsets[i])
+		if slices.Contains(words, tokens[i]) {
+			b.WriteString("<mark>" + template.HTMLEscapeString(word) + "</mark>")
+		} else {
+			b.WriteString(template.HTMLEscapeString(word))
+		}
+		pos = offsets[i] + len(word)
+	}
+	if end < len(tokens) {
+		b.WriteString(" …")
+	}
+	return template.HTML(b.String())
+}
+
+func wordAt(text string, offset int) string {
+	end := strings.IndexFunc(text[offset:], func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if end < 0 {
+		return text[offset:]
+	}
+	return text[offset : offset+end]
+}
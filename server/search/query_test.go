@@ -0,0 +1,99 @@
+package search
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse(dateLayout, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name string
+		raw  string
+		want Query
+	}{
+		{name: "empty", raw: ""},
+		{name: "only spaces", raw: " \t\n "},
+		{name: "only punctuation", raw: `!!! ?? -- ...`},
+		{name: "empty phrase", raw: `""`},
+		{name: "words", raw: "Hello  World", want: Query{Terms: []string{"hello", "world"}}},
+		{name: "punctuation splits words", raw: "C++ & go-lang", want: Query{Terms: []string{"c", "go", "lang"}}},
+		{
+			name: "phrase",
+			raw:  `"Exact  phrase" other`,
+			want: Query{Terms: []string{"other"}, Phrases: [][]string{{"exact", "phrase"}}},
+		},
+		{name: "phrase of one word", raw: `"single"`, want: Query{Terms: []string{"single"}}},
+		{name: "unclosed phrase", raw: `"unclosed phrase`, want: Query{Phrases: [][]string{{"unclosed", "phrase"}}}},
+		{
+			name: "phrase glued to a word",
+			raw:  `word"a phrase"`,
+			want: Query{Terms: []string{"word"}, Phrases: [][]string{{"a", "phrase"}}},
+		},
+		{name: "author", raw: "author:Alice go", want: Query{Terms: []string{"go"}, Author: "Alice"}},
+		{name: "field names ignore case", raw: "AUTHOR:bob", want: Query{Author: "bob"}},
+		{name: "author without name", raw: "author:", want: Query{Terms: []string{"author"}}},
+		{
+			name: "date range",
+			raw:  "after:2024-01-02 before:2024-02-03",
+			want: Query{After: day("2024-01-02"), Before: day("2024-02-04")},
+		},
+		{name: "invalid date", raw: "after:yesterday", want: Query{Terms: []string{"after", "yesterday"}}},
+		{name: "unknown field", raw: "title:foo", want: Query{Terms: []string{"title", "foo"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseQuery(tt.raw)
+			if !slices.Equal(got.Terms, tt.want.Terms) ||
+				!slices.EqualFunc(got.Phrases, tt.want.Phrases, slices.Equal) ||
+				got.Author != tt.want.Author ||
+				!got.After.Equal(tt.want.After) || !got.Before.Equal(tt.want.Before) {
+				t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+			if empty := tt.want.IsEmpty(); got.IsEmpty() != empty {
+				t.Errorf("ParseQuery(%q).IsEmpty() = %v, want %v", tt.raw, got.IsEmpty(), empty)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("filler ", 40) + "needle " + strings.Repeat("filler ", 40)
+
+	tests := []struct {
+		name  string
+		text  string
+		words []string
+		want  string
+	}{
+		{name: "empty", text: "", words: []string{"go"}, want: ""},
+		{name: "marks matches", text: "Go is fun, go!", words: []string{"go"}, want: "<mark>Go</mark> is fun, <mark>go</mark>"},
+		{name: "no match", text: "Nothing here", words: []string{"go"}, want: "Nothing here"},
+		{name: "escapes", text: "a <b> & go", words: []string{"go"}, want: "a &lt;b&gt; &amp; <mark>go</mark>"},
+		{
+			name:  "cuts long text around the match",
+			text:  long,
+			words: []string{"needle"},
+			want: "… " + strings.TrimSpace(strings.Repeat("filler ", snippetWords/3)) +
+				" <mark>needle</mark> " + strings.TrimSpace(strings.Repeat("filler ", snippetWords-snippetWords/3-1)) + " …",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(snippet(tt.text, tt.words)); got != tt.want {
+				t.Errorf("snippet(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
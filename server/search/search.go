@@ -0,0 +1,236 @@
+package search
+
+import (
+	"html/template"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Gap between the title and text positions, so that
+// phrases never match across the two fields
+const fieldGap = 1 << 16
+
+// Document is a single searchable post or comment
+type Document struct {
+	ID     string // Storage location, e.g. /user/post:1
+	Kind   string // "post" or "comment"
+	Author string
+	Title  string // For comments the title of the post they belong to
+	Text   string
+	Date   time.Time
+	Link   string
+}
+
+type Result struct {
+	Document
+	Snippet template.HTML
+	Score   float64
+}
+
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]Document
+	postings map[string]map[string][]int // term -> document ID -> positions
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]Document),
+		postings: make(map[string]map[string][]int),
+	}
+}
+
+// tokenize splits text into lowercase words
+// along with their byte offsets in the original text
+func tokenize(text string) ([]string, []int) {
+	var words []string
+	var offsets []int
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWord && start < 0:
+			start = i
+		case !isWord && start >= 0:
+			words = append(words, strings.ToLower(text[start:i]))
+			offsets = append(offsets, start)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, strings.ToLower(text[start:]))
+		offsets = append(offsets, start)
+	}
+	return words, offsets
+}
+
+// Add indexes a document, replacing a previous version with the same ID
+func (idx *Index) Add(doc Document) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.docs[doc.ID]; ok {
+		idx.remove(doc.ID)
+	}
+	idx.docs[doc.ID] = doc
+
+	// Comments carry the post title only for display
+	if doc.Kind == "post" {
+		titleWords, _ := tokenize(doc.Title)
+		for pos, word := range titleWords {
+			idx.addPosting(word, doc.ID, pos)
+		}
+	}
+	textWords, _ := tokenize(doc.Text)
+	for pos, word := range textWords {
+		idx.addPosting(word, doc.ID, fieldGap+pos)
+	}
+}
+
+func (idx *Index) Remove(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+}
+
+// Only use when `mu` is locked
+func (idx *Index) remove(id string) {
+	delete(idx.docs, id)
+	for word, docs := range idx.postings {
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(idx.postings, word)
+		}
+	}
+}
+
+// Only use when `mu` is locked
+func (idx *Index) addPosting(word, id string, pos int) {
+	docs, ok := idx.postings[word]
+	if !ok {
+		docs = make(map[string][]int)
+		idx.postings[word] = docs
+	}
+	docs[id] = append(docs[id], pos)
+}
+
+// Search returns up to `limit` documents matching all terms and phrases
+// of the query, best matches first
+func (idx *Index) Search(q Query, limit int) []Result {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var results []Result
+	for id, doc := range idx.candidates(q) {
+		if !q.matchesFilters(doc) {
+			continue
+		}
+		score, ok := idx.score(q, id)
+		if !ok {
+			continue
+		}
+		results = append(results, Result{
+			Document: doc,
+			Snippet:  snippet(doc.Text, q.words()),
+			Score:    score,
+		})
+	}
+
+	slices.SortFunc(results, func(a, b Result) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return b.Date.Compare(a.Date)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// candidates returns documents containing the rarest query word,
+// or all documents when the query consists of filters only
+func (idx *Index) candidates(q Query) map[string]Document {
+	words := q.words()
+	if len(words) == 0 {
+		return idx.docs
+	}
+
+	rarest := words[0]
+	for _, w := range words[1:] {
+		if len(idx.postings[w]) < len(idx.postings[rarest]) {
+			rarest = w
+		}
+	}
+
+	candidates := make(map[string]Document, len(idx.postings[rarest]))
+	for id := range idx.postings[rarest] {
+		candidates[id] = idx.docs[id]
+	}
+	return candidates
+}
+
+// score computes a tf-idf score of the document, the second return value
+// reports whether the document matches every term and phrase
+func (idx *Index) score(q Query, id string) (float64, bool) {
+	total := float64(len(idx.docs))
+	score := 0.0
+	for _, w := range q.Terms {
+		positions, ok := idx.postings[w][id]
+		if !ok {
+			return 0, false
+		}
+		idf := math.Log(1 + total/float64(len(idx.postings[w])))
+		for _, pos := range positions {
+			if pos < fieldGap {
+				score += 2 * idf // Title matches count more
+			} else {
+				score += idf
+			}
+		}
+	}
+	for _, phrase := range q.Phrases {
+		if !idx.matchPhrase(phrase, id) {
+			return 0, false
+		}
+		score += float64(len(phrase))
+	}
+	return score, true
+}
+
+// Only use when `mu` is locked
+func (idx *Index) matchPhrase(phrase []string, id string) bool {
+	if len(phrase) == 0 {
+		return true
+	}
+	first, ok := idx.postings[phrase[0]][id]
+	if !ok {
+		return false
+	}
+	for _, start := range first {
+		matched := true
+		for i, w := range phrase[1:] {
+			if !slices.Contains(idx.postings[w][id], start+i+1) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
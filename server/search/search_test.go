@@ -0,0 +1,131 @@
+package search
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func testIndex() *Index {
+	date := func(s string) time.Time {
+		d, _ := time.Parse(dateLayout, s)
+		return d
+	}
+	idx := NewIndex()
+	for _, doc := range []Document{
+		{
+			ID: "/alice/post:0", Kind: "post", Author: "alice",
+			Title: "Go generics", Text: "Generics arrived in Go 1.18 with type parameters.",
+			Date: date("2024-01-10"),
+		},
+		{
+			ID: "/bob/post:0", Kind: "post", Author: "bob",
+			Title: "Rust ownership", Text: "Ownership and borrowing in Rust, compared to Go.",
+			Date: date("2024-02-10"),
+		},
+		{
+			// Comments show the title of their post, it isn't searched
+			ID: "/carol/comment:0", Kind: "comment", Author: "carol",
+			Title: "Go generics", Text: "Type parameters make generic code easier in Go.",
+			Date: date("2024-03-01"),
+		},
+		{
+			ID: "/alice/post:1", Kind: "post", Author: "alice",
+			Title: "Cooking", Text: "A recipe for pasta. The type of pasta matters.",
+			Date: date("2024-04-01"),
+		},
+	} {
+		idx.Add(doc)
+	}
+	return idx
+}
+
+func ids(results []Result) []string {
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	idx := testIndex()
+
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []string
+	}{
+		// Title matches count twice, equal scores rank newer documents first
+		{name: "ranking", query: "go", want: []string{"/alice/post:0", "/carol/comment:0", "/bob/post:0"}},
+		{name: "limit", query: "go", limit: 1, want: []string{"/alice/post:0"}},
+		{name: "all terms", query: "go ownership", want: []string{"/bob/post:0"}},
+		{name: "case", query: "RUST", want: []string{"/bob/post:0"}},
+		{name: "no match", query: "missing", want: nil},
+		{name: "one missing term", query: "go missing", want: nil},
+		{name: "comment titles", query: "generics", want: []string{"/alice/post:0"}},
+		{name: "phrase", query: `"type parameters"`, want: []string{"/carol/comment:0", "/alice/post:0"}},
+		{name: "phrase order", query: `"parameters type"`, want: nil},
+		{name: "phrase across title and text", query: `"generics generics"`, want: nil},
+		{name: "phrase and term", query: `"type parameters" generic`, want: []string{"/carol/comment:0"}},
+		{name: "author only", query: "author:alice", want: []string{"/alice/post:1", "/alice/post:0"}},
+		{name: "author ignores case", query: "author:ALICE pasta", want: []string{"/alice/post:1"}},
+		{name: "author and term", query: "go author:bob", want: []string{"/bob/post:0"}},
+		{name: "unknown author", query: "go author:dave", want: nil},
+		{name: "after", query: "go after:2024-02-10", want: []string{"/carol/comment:0", "/bob/post:0"}},
+		{name: "before includes the day", query: "go before:2024-02-10", want: []string{"/alice/post:0", "/bob/post:0"}},
+		{name: "date range", query: "after:2024-02-01 before:2024-03-01", want: []string{"/carol/comment:0", "/bob/post:0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ids(idx.Search(ParseQuery(tt.query), tt.limit))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	results := testIndex().Search(ParseQuery(`pasta "type of"`), 0)
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	want := "A recipe for <mark>pasta</mark>. The <mark>type</mark> <mark>of</mark> <mark>pasta</mark> matters"
+	if got := string(results[0].Snippet); got != want {
+		t.Errorf("snippet = %q, want %q", got, want)
+	}
+}
+
+func TestAddReplaces(t *testing.T) {
+	idx := testIndex()
+	idx.Add(Document{ID: "/bob/post:0", Kind: "post", Author: "bob", Title: "Zig", Text: "Comptime"})
+
+	if idx.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", idx.Len())
+	}
+	if got := ids(idx.Search(ParseQuery("rust"), 0)); got != nil {
+		t.Errorf("old version still found: %v", got)
+	}
+	if got := ids(idx.Search(ParseQuery("comptime"), 0)); !slices.Equal(got, []string{"/bob/post:0"}) {
+		t.Errorf("new version = %v, want [/bob/post:0]", got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	idx := testIndex()
+	idx.Remove("/alice/post:0")
+	idx.Remove("/nobody/post:0")
+
+	if idx.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", idx.Len())
+	}
+	if got := ids(idx.Search(ParseQuery("generics"), 0)); got != nil {
+		t.Errorf("removed document still found: %v", got)
+	}
+	if got := ids(idx.Search(ParseQuery("go"), 0)); !slices.Equal(got, []string{"/carol/comment:0", "/bob/post:0"}) {
+		t.Errorf("Search(go) = %v", got)
+	}
+}
@@ -0,0 +1,398 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"forumapp/events"
+	"forumapp/tmpl"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Federation data is kept next to the local data it belongs to:
+//
+//	users/<user>/ap_key                     private key of a local actor, PEM
+//	users/<user>/ap_followers/<hash>        actor ID and inbox of a remote follower
+//	users/<user>/post/<id>/ap_followers/... remote followers of a thread
+//	users/<user>/ap_following/<handle>      state of a follow of a remote account
+//	users/<user>/{remote,remote_inbox}      actor ID and inbox, only for remote users
+//	<post or comment>/ap_id                 ID of content that came from another server
+//
+// storage/ap_objects/<hash> and storage/ap_actors/<hash> find content
+// and remote users by the SHA-256 of their ID
+const (
+	apObjectsDir = "../storage/ap_objects"
+	apActorsDir  = "../storage/ap_actors"
+
+	FollowPending  = "pending"
+	FollowAccepted = "accepted"
+	FollowRejected = "rejected"
+	FollowFailed   = "failed"
+)
+
+// Follower is an actor of another server following a user or a thread
+type Follower struct {
+	ActorID string
+	Inbox   string
+}
+
+func hashID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseHandle checks a user@host handle of another server,
+// a leading @ is dropped
+func ParseHandle(handle string) (string, error) {
+	handle = strings.TrimPrefix(strings.TrimSpace(handle), "@")
+	user, host, ok := strings.Cut(handle, "@")
+	if !ok || user == "" || host == "" || strings.ContainsAny(handle, "/\\ \t\n?#") {
+		return "", ErrInvalidHandle
+	}
+	if u, err := url.Parse("//" + host); err != nil || u.Host != host {
+		return "", ErrInvalidHandle
+	}
+	return handle, nil
+}
+
+// Remote users are named by their handle, local usernames can't contain @
+func isRemoteUser(username string) bool {
+	_, err := os.Stat(filepath.Join("../storage/users", username, "remote"))
+	return err == nil
+}
+
+// IsRemoteUser reports whether the user is an account of another server
+func (s *Storage) IsRemoteUser(username string) bool {
+	return userExists(username) && isRemoteUser(username)
+}
+
+// ActorKey returns the signing key of a local user, it's generated
+// the first time the user federates
+func (s *Storage) ActorKey(username string) (*rsa.PrivateKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !userExists(username) || isRemoteUser(username) {
+		return nil, ErrNotFound
+	}
+	keyFile := filepath.Join("../storage/users", username, "ap_key")
+	if content, err := os.ReadFile(keyFile); err == nil {
+		block, _ := pem.Decode(content)
+		if block == nil {
+			return nil, fmt.Errorf("failed to decode %s", keyFile)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", keyFile, err)
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s is not an RSA key", keyFile)
+		}
+		return rsaKey, nil
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate actor key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode actor key: %w", err)
+	}
+	content := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(keyFile, content, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", keyFile, err)
+	}
+	return key, nil
+}
+
+// AddRemoteUser creates the local stand-in of an account of another
+// server, or updates its inbox. Remote users have no password and
+// can't log in, their posts and comments come from their server.
+func (s *Storage) AddRemoteUser(handle, actorID, inbox string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	handle, err := ParseHandle(handle)
+	if err != nil {
+		return err
+	}
+	userdir := filepath.Join("../storage/users", handle)
+	if err := os.MkdirAll(apActorsDir, 0750); err != nil {
+		return fmt.Errorf("failed to create dir %s: %w", apActorsDir, err)
+	}
+	files := map[string]string{
+		filepath.Join(userdir, "remote"):            actorID,
+		filepath.Join(userdir, "remote_inbox"):      inbox,
+		filepath.Join(apActorsDir, hashID(actorID)): handle,
+	}
+
+	if userExists(handle) {
+		if !isRemoteUser(handle) {
+			return ErrUserExists
+		}
+		return writeFiles(files)
+	}
+	files[filepath.Join(userdir, "creation_date")] = time.Now().Format(creationDateLayout)
+	return createPaths(
+		[]string{
+			userdir,
+			filepath.Join(userdir, "comment"),
+			filepath.Join(userdir, "post"),
+		},
+		files,
+	)
+}
+
+// RemoteUser returns the actor ID and inbox of a remote user
+func (s *Storage) RemoteUser(username string) (actorID, inbox string, ok bool) {
+	if !userExists(username) {
+		return "", "", false
+	}
+	files, ok := readFiles(filepath.Join("../storage/users", username), "remote", "remote_inbox")
+	if !ok {
+		return "", "", false
+	}
+	return strings.TrimSpace(files["remote"]), strings.TrimSpace(files["remote_inbox"]), true
+}
+
+// RemoteUserByActor returns the remote user of an actor ID
+func (s *Storage) RemoteUserByActor(actorID string) (string, bool) {
+	handle, err := os.ReadFile(filepath.Join(apActorsDir, hashID(actorID)))
+	if err != nil || !userExists(string(handle)) {
+		return "", false
+	}
+	return string(handle), true
+}
+
+// followersDir returns the followers dir of a user (/<user>)
+// or of a thread (/<user>/post:<id>)
+func followersDir(location string) (string, error) {
+	username, rest, _ := strings.Cut(strings.TrimPrefix(location, "/"), "/")
+	if rest == "" {
+		if !userExists(username) {
+			return "", ErrNotFound
+		}
+		return filepath.Join("../storage/users", username, "ap_followers"), nil
+	}
+	_, resourcePath, _, err := parseUserResourceURI(location)
+	if err != nil || !strings.HasPrefix(rest, "post:") {
+		return "", ErrInvalidURI
+	}
+	if _, err := os.Stat(resourcePath); err != nil {
+		return "", ErrNotFound
+	}
+	return filepath.Join(resourcePath, "ap_followers"), nil
+}
+
+// AddFollower adds a remote follower to a user or a thread
+func (s *Storage) AddFollower(location string, follower Follower) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := followersDir(location)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create dir %s: %w", dir, err)
+	}
+	return writeFiles(map[string]string{
+		filepath.Join(dir, hashID(follower.ActorID)): follower.ActorID + "\n" + follower.Inbox,
+	})
+}
+
+func (s *Storage) RemoveFollower(location, actorID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := followersDir(location)
+	if err != nil {
+		return err
+	}
+	followerFile := filepath.Join(dir, hashID(actorID))
+	if err := os.Remove(followerFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", followerFile, err)
+	}
+	return nil
+}
+
+// GetFollowers returns the remote followers of a user or a thread
+func (s *Storage) GetFollowers(location string) []Follower {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := followersDir(location)
+	if err != nil {
+		return []Follower{}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return []Follower{}
+	}
+
+	followers := []Follower{}
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		actorID, inbox, ok := strings.Cut(string(content), "\n")
+		if !ok {
+			continue
+		}
+		followers = append(followers, Follower{ActorID: actorID, Inbox: inbox})
+	}
+	return followers
+}
+
+// FollowRemote asks to follow an account of another server,
+// the follow stays pending until the server accepts it
+func (s *Storage) FollowRemote(username, handle string) error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	handle, err := ParseHandle(handle)
+	if err != nil {
+		return err
+	}
+	if !userExists(username) || isRemoteUser(username) {
+		return ErrNotFound
+	}
+	followingDir := filepath.Join("../storage/users", username, "ap_following")
+	if err := os.MkdirAll(followingDir, 0750); err != nil {
+		return fmt.Errorf("failed to create dir %s: %w", followingDir, err)
+	}
+	if err := writeFiles(map[string]string{filepath.Join(followingDir, handle): FollowPending}); err != nil {
+		return err
+	}
+
+	emit(s, events.RemoteFollow{Username: username, Handle: handle})
+	return nil
+}
+
+func (s *Storage) UnfollowRemote(username, handle string) error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	handle, err := ParseHandle(handle)
+	if err != nil {
+		return err
+	}
+	followFile := filepath.Join("../storage/users", username, "ap_following", handle)
+	if err := os.Remove(followFile); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to remove %s: %w", followFile, err)
+	}
+
+	emit(s, events.RemoteUnfollow{Username: username, Handle: handle})
+	return nil
+}
+
+// SetFollowState records the answer of the remote server to a follow
+func (s *Storage) SetFollowState(username, handle, state string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	followFile := filepath.Join("../storage/users", username, "ap_following", handle)
+	if _, err := os.Stat(followFile); err != nil || strings.ContainsAny(handle, "/\\") {
+		return ErrNotFound
+	}
+	return writeFiles(map[string]string{followFile: state})
+}
+
+// GetRemoteFollows lists the remote accounts the user follows
+func (s *Storage) GetRemoteFollows(username string) []tmpl.RemoteFollow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	followingDir := filepath.Join("../storage/users", username, "ap_following")
+	entries, err := os.ReadDir(followingDir)
+	if err != nil {
+		return []tmpl.RemoteFollow{}
+	}
+
+	follows := []tmpl.RemoteFollow{}
+	for _, entry := range entries {
+		state, err := os.ReadFile(filepath.Join(followingDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		follows = append(follows, tmpl.RemoteFollow{
+			Handle: entry.Name(),
+			State:  strings.TrimSpace(string(state)),
+		})
+	}
+	return follows
+}
+
+// LocalFollowers returns the local users with an accepted
+// follow of the remote account
+func (s *Storage) LocalFollowers(handle string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, err := os.ReadDir("../storage/users")
+	if err != nil || strings.ContainsAny(handle, "/\\") {
+		return []string{}
+	}
+	followers := []string{}
+	for _, user := range users {
+		state, err := os.ReadFile(filepath.Join("../storage/users", user.Name(), "ap_following", handle))
+		if err == nil && strings.TrimSpace(string(state)) == FollowAccepted {
+			followers = append(followers, user.Name())
+		}
+	}
+	return followers
+}
+
+// SetObjectID remembers the ID a post or comment has on the server it came from
+func (s *Storage) SetObjectID(location, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, resourcePath, _, err := parseUserResourceURI(location)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(apObjectsDir, 0750); err != nil {
+		return fmt.Errorf("failed to create dir %s: %w", apObjectsDir, err)
+	}
+	return writeFiles(map[string]string{
+		filepath.Join(resourcePath, "ap_id"):    id,
+		filepath.Join(apObjectsDir, hashID(id)): location,
+	})
+}
+
+// ObjectLocation finds a post or comment of another server by its ID
+func (s *Storage) ObjectLocation(id string) (string, bool) {
+	location, err := os.ReadFile(filepath.Join(apObjectsDir, hashID(id)))
+	if err != nil {
+		return "", false
+	}
+	return string(location), true
+}
+
+// ObjectID returns the ID of a post or comment of another server
+func (s *Storage) ObjectID(location string) (string, bool) {
+	_, resourcePath, _, err := parseUserResourceURI(location)
+	if err != nil {
+		return "", false
+	}
+	id, err := os.ReadFile(filepath.Join(resourcePath, "ap_id"))
+	if err != nil {
+		return "", false
+	}
+	return string(id), true
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"fmt"
+	"forumapp/tmpl"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DigestPeriod returns the time between two digests of the given frequency
+func DigestPeriod(frequency string) time.Duration {
+	switch frequency {
+	case DigestDaily:
+		return 24 * time.Hour
+	case DigestWeekly:
+		return 7 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+type DigestSubscription struct {
+	Username   string
+	Email      string
+	Frequency  string
+	LastDigest time.Time
+}
+
+// SetDigest changes how often the user gets digest emails.
+// An empty frequency turns digests off.
+//
+// Digest settings are stored in users/<user>/digest, the time
+// of the last digest in users/<user>/last_digest
+func (s *Storage) SetDigest(username, frequency string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !userExists(username) {
+		return ErrNotFound
+	}
+	digestFile := filepath.Join("../storage/users", username, "digest")
+	lastDigestFile := filepath.Join("../storage/users", username, "last_digest")
+
+	if frequency == "" {
+		if err := os.Remove(digestFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", digestFile, err)
+		}
+		return nil
+	}
+	if DigestPeriod(frequency) == 0 {
+		return ErrInvalidDigest
+	}
+
+	files := map[string]string{digestFile: frequency}
+	// Start counting from now, so the first digest doesn't cover all history
+	if _, err := os.Stat(lastDigestFile); os.IsNotExist(err) {
+		files[lastDigestFile] = time.Now().Format(time.RFC3339)
+	}
+	return writeFiles(files)
+}
+
+func (s *Storage) GetDigest(username string) string {
+	frequency, err := os.ReadFile(filepath.Join("../storage/users", username, "digest"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(frequency))
+}
+
+// DigestSubscriptions lists the users that opted in to digests
+func (s *Storage) DigestSubscriptions() []DigestSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, err := os.ReadDir("../storage/users")
+	if err != nil {
+		return []DigestSubscription{}
+	}
+
+	var subscriptions []DigestSubscription
+	for _, user := range users {
+		files, ok := readFiles(filepath.Join("../storage/users", user.Name()), "digest", "email")
+		if !ok {
+			continue
+		}
+		subscription := DigestSubscription{
+			Username:  user.Name(),
+			Email:     strings.TrimSpace(files["email"]),
+			Frequency: strings.TrimSpace(files["digest"]),
+		}
+		lastDigest, err := os.ReadFile(filepath.Join("../storage/users", user.Name(), "last_digest"))
+		if err == nil {
+			subscription.LastDigest, _ = time.Parse(time.RFC3339, strings.TrimSpace(string(lastDigest)))
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions
+}
+
+func (s *Storage) SetLastDigest(username string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !userExists(username) {
+		return ErrNotFound
+	}
+	return writeFiles(map[string]string{
+		filepath.Join("../storage/users", username, "last_digest"): t.Format(time.RFC3339),
+	})
+}
+
+// GetTopPostsSince returns up to count posts created after since,
+// the highest voted first
+func (s *Storage) GetTopPostsSince(since time.Time, count int) []tmpl.ArticleItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type scoredPost struct {
+		article tmpl.ArticleItem
+		votes   int
+	}
+
+	users, err := os.ReadDir("../storage/users")
+	if err != nil {
+		return []tmpl.ArticleItem{}
+	}
+
+	var posts []scoredPost
+	for _, user := range users {
+		userPosts := filepath.Join("../storage/users", user.Name(), "post")
+		entries, err := os.ReadDir(userPosts)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			files, ok := readFiles(filepath.Join(userPosts, entry.Name()), "title", "creation_date")
+			if !ok {
+				continue
+			}
+			// Creation dates are stored in local time without a zone
+			created, err := time.ParseInLocation(creationDateLayout,
+				strings.TrimSpace(files["creation_date"]), time.Local)
+			if err != nil || !created.After(since) {
+				continue
+			}
+			voteCache, _ := os.ReadFile(filepath.Join(userPosts, entry.Name(), "vote_cache"))
+			votes, _ := strconv.Atoi(strings.TrimSpace(string(voteCache)))
+			posts = append(posts, scoredPost{
+				article: tmpl.ArticleItem{
+					Title:        files["title"],
+					Author:       user.Name(),
+					AuthorKarma:  readKarma(user.Name()),
+					CreationDate: files["creation_date"],
+					PostLink:     "/u/" + user.Name() + "/post:" + entry.Name(),
+				},
+				votes: votes,
+			})
+		}
+	}
+
+	slices.SortStableFunc(posts, func(a, b scoredPost) int {
+		return b.votes - a.votes
+	})
+
+	articles := []tmpl.ArticleItem{}
+	for _, post := range posts[:min(count, len(posts))] {
+		articles = append(articles, post.article)
+	}
+	return articles
+}
+
+// GetUnreadNotificationsSince returns unread notifications of the user
+// created after since, newest first
+func (s *Storage) GetUnreadNotificationsSince(username string, since time.Time) []tmpl.Notification {
+	notifications := []tmpl.Notification{}
+	for _, notification := range s.GetNotifications(username) {
+		created, err := time.ParseInLocation(creationDateLayout,
+			strings.TrimSpace(notification.CreationDate), time.Local)
+		if err != nil || !notification.Unread || created.Before(since.Truncate(time.Minute)) {
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// UserFeed returns the newest posts of the user
+func (s *Storage) UserFeed(username string, limit int) ([]NewsArticle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !userExists(username) {
+		return nil, ErrNotFound
+	}
+	entries, _ := os.ReadDir(filepath.Join("../storage/users", username, "post"))
+	locations := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		locations = append(locations, "/"+username+"/post:"+entry.Name())
+	}
+	return newest(locations, limit), nil
+}
+
+// ThreadFeed returns the newest comments and replies on the post
+func (s *Storage) ThreadFeed(post string, limit int) ([]NewsArticle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, postPath, _, err := parseUserResourceURI(post)
+	if err != nil || !strings.Contains(post, "/post:") {
+		return nil, ErrNotFound
+	}
+	if _, err := os.Stat(postPath); err != nil {
+		return nil, ErrNotFound
+	}
+	return newest(commentLocations(postPath, "comments"), limit), nil
+}
+
+// TagFeed returns the newest posts with the tag
+func (s *Storage) TagFeed(tag string, limit int) ([]NewsArticle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	locations, ok := taggedPosts(tag)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return newest(locations, limit), nil
+}
+
+// commentLocations returns the locations of the comments and replies below resourcePath
+//
+// Only use when `mu` is locked
+func commentLocations(resourcePath, dir string) []string {
+	refs, err := os.ReadDir(filepath.Join(resourcePath, dir))
+	if err != nil {
+		return nil
+	}
+
+	var locations []string
+	for _, ref := range refs {
+		commentURI, err := os.ReadFile(filepath.Join(resourcePath, dir, ref.Name()))
+		if err != nil {
+			continue
+		}
+		location := strings.TrimSpace(string(commentURI))
+		_, commentPath, _, err := parseUserResourceURI(location)
+		if err != nil {
+			continue
+		}
+		locations = append(locations, location)
+		locations = append(locations, commentLocations(commentPath, "replies")...)
+	}
+	return locations
+}
+
+// newest reads the articles at locations, the newest first
+//
+// Only use when `mu` is locked
+func newest(locations []string, limit int) []NewsArticle {
+	articles := make([]NewsArticle, 0, len(locations))
+	for _, location := range locations {
+		article, err := readNewsArticle(location)
+		if err != nil {
+			continue
+		}
+		articles = append(articles, article)
+	}
+	// Dates only have minutes, longer ids of the same author are newer
+	slices.SortFunc(articles, func(a, b NewsArticle) int {
+		return cmp.Or(
+			b.CreationDate.Compare(a.CreationDate),
+			cmp.Compare(len(b.Location), len(a.Location)),
+			strings.Compare(b.Location, a.Location),
+		)
+	})
+	return articles[:min(limit, len(articles))]
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Karma of a user is the sum of votes on their posts and comments,
+// cached in users/<user>/karma and updated on every vote
+
+func voteValue(vote string) int {
+	switch strings.TrimSpace(vote) {
+	case "+":
+		return 1
+	case "-":
+		return -1
+	default:
+		return 0
+	}
+}
+
+// computeKarma sums the vote files of all posts and comments of the user
+func computeKarma(username string) int {
+	karma := 0
+	for _, kind := range []string{"post", "comment"} {
+		resourcesDir := filepath.Join("../storage/users", username, kind)
+		resources, _ := os.ReadDir(resourcesDir)
+		for _, resource := range resources {
+			votesDir := filepath.Join(resourcesDir, resource.Name(), "votes")
+			votes, _ := os.ReadDir(votesDir)
+			for _, vote := range votes {
+				content, err := os.ReadFile(filepath.Join(votesDir, vote.Name()))
+				if err != nil {
+					continue
+				}
+				karma += voteValue(string(content))
+			}
+		}
+	}
+	return karma
+}
+
+// readKarma returns the cached karma of the user,
+// computing it if the cache doesn't exist yet
+func readKarma(username string) int {
+	content, err := os.ReadFile(filepath.Join("../storage/users", username, "karma"))
+	if err != nil {
+		return computeKarma(username)
+	}
+	karma, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return computeKarma(username)
+	}
+	return karma
+}
+
+// Only use when `mu` is locked
+func setKarma(username string, karma int) error {
+	if !userExists(username) {
+		return nil
+	}
+	return writeFiles(map[string]string{
+		filepath.Join("../storage/users", username, "karma"): strconv.Itoa(karma),
+	})
+}
+
+func (s *Storage) GetKarma(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return readKarma(username)
+}
+
+// RebuildKarma recomputes the karma of every user from the vote files
+func (s *Storage) RebuildKarma() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, err := os.ReadDir("../storage/users")
+	if err != nil {
+		return fmt.Errorf("failed to read users dir: %w", err)
+	}
+
+	for _, user := range users {
+		if !user.IsDir() {
+			continue
+		}
+		karma := computeKarma(user.Name())
+		if err := setKarma(user.Name(), karma); err != nil {
+			return err
+		}
+		log.Printf("Karma of %s: %d\n", user.Name(), karma)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Key of the reply addresses in mailing list emails
+const mailingListKeyFile = "../storage/mailing_list_key"
+
+type MailingListMember struct {
+	Username string
+	Email    string
+}
+
+// SetMailingList turns comment emails of the user on or off, members
+// of the mailing list have an empty users/<user>/mailing_list file
+func (s *Storage) SetMailingList(username string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !userExists(username) || isRemoteUser(username) {
+		return ErrNotFound
+	}
+	flagFile := filepath.Join("../storage/users", username, "mailing_list")
+	if !enabled {
+		if err := os.Remove(flagFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", flagFile, err)
+		}
+		return nil
+	}
+	return writeFiles(map[string]string{flagFile: ""})
+}
+
+func (s *Storage) GetMailingList(username string) bool {
+	_, err := os.Stat(filepath.Join("../storage/users", username, "mailing_list"))
+	return err == nil
+}
+
+// MailingListMembers returns the members that get an email about a new
+// comment: the authors of the post and of the parent, and the watchers
+// of the post. The author of the comment is left out.
+func (s *Storage) MailingListMembers(author, parent, post string) []MailingListMember {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var usernames []string
+	for _, location := range []string{post, parent} {
+		if username, _, _, err := parseUserResourceURI(location); err == nil {
+			usernames = append(usernames, username)
+		}
+	}
+	if _, postPath, _, err := parseUserResourceURI(post); err == nil {
+		watchers, _ := os.ReadDir(filepath.Join(postPath, "watchers"))
+		for _, watcher := range watchers {
+			usernames = append(usernames, watcher.Name())
+		}
+	}
+	slices.Sort(usernames)
+
+	var members []MailingListMember
+	for _, username := range slices.Compact(usernames) {
+		if username == author || !userExists(username) {
+			continue
+		}
+		files, ok := readFiles(filepath.Join("../storage/users", username), "mailing_list", "email")
+		if !ok {
+			continue
+		}
+		members = append(members, MailingListMember{Username: username, Email: strings.TrimSpace(files["email"])})
+	}
+	return members
+}
+
+// UserByEmail finds the local user registered with the email address
+func (s *Storage) UserByEmail(email string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", false
+	}
+	users, err := os.ReadDir("../storage/users")
+	if err != nil {
+		return "", false
+	}
+	for _, user := range users {
+		content, err := os.ReadFile(filepath.Join("../storage/users", user.Name(), "email"))
+		if err != nil || isRemoteUser(user.Name()) {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(string(content)), email) {
+			return user.Name(), true
+		}
+	}
+	return "", false
+}
+
+// MailingListKey returns the key that signs reply addresses,
+// it's generated the first time it's needed
+func (s *Storage) MailingListKey() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if content, err := os.ReadFile(mailingListKeyFile); err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", mailingListKeyFile, err)
+		}
+		return key, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate mailing list key: %w", err)
+	}
+	if err := os.WriteFile(mailingListKeyFile, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", mailingListKeyFile, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"fmt"
+	"forumapp/tmpl"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Private messages are stored under both participants as
+// users/<user>/messages/<other user>/<id>/{from,text,creation_date},
+// messages not yet seen by <user> lack the `read` marker.
+// Blocked users are stored as empty files in users/<user>/blocked.
+
+func conversationDir(username, other string) string {
+	return filepath.Join("../storage/users", username, "messages", other)
+}
+
+// Only use when `mu` is locked
+func isBlocked(username, other string) bool {
+	_, err := os.Stat(filepath.Join("../storage/users", username, "blocked", other))
+	return err == nil
+}
+
+// Only use when `mu` is locked
+func addMessage(owner, other, from, text, creationDate string, read bool) error {
+	dir := conversationDir(owner, other)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create dir %s: %w", dir, err)
+	}
+	messageDir, _, err := getNextName(dir)
+	if err != nil {
+		return fmt.Errorf("failed to get next message id: %w", err)
+	}
+
+	files := map[string]string{
+		filepath.Join(messageDir, "from"):          from,
+		filepath.Join(messageDir, "text"):          text,
+		filepath.Join(messageDir, "creation_date"): creationDate,
+	}
+	if read {
+		files[filepath.Join(messageDir, "read")] = ""
+	}
+	return createPaths([]string{messageDir}, files)
+}
+
+// SendMessage stores a private message in the conversation of both users
+func (s *Storage) SendMessage(from, to, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if strings.TrimSpace(text) == "" {
+		return ErrEmptyMessage
+	}
+	if from == to || !userExists(from) || !userExists(to) {
+		return ErrNotFound
+	}
+	if isBlocked(to, from) || isBlocked(from, to) {
+		return ErrBlocked
+	}
+
+	creationDate := time.Now().Format(creationDateLayout)
+	if err := addMessage(from, to, from, text, creationDate, true); err != nil {
+		return err
+	}
+	return addMessage(to, from, from, text, creationDate, false)
+}
+
+// Only use when `mu` is locked
+func readMessages(username, other string) []tmpl.Message {
+	if !userExists(other) {
+		return []tmpl.Message{}
+	}
+	dir := conversationDir(username, other)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return []tmpl.Message{}
+	}
+
+	var messages []tmpl.Message
+	for _, entry := range entries {
+		id, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		files, ok := readFiles(filepath.Join(dir, entry.Name()), "from", "text", "creation_date")
+		if !ok {
+			continue
+		}
+		_, err = os.Stat(filepath.Join(dir, entry.Name(), "read"))
+		messages = append(messages, tmpl.Message{
+			ID:           id,
+			From:         files["from"],
+			Text:         files["text"],
+			CreationDate: files["creation_date"],
+			Unread:       os.IsNotExist(err),
+		})
+	}
+
+	slices.SortFunc(messages, func(a, b tmpl.Message) int {
+		return a.ID - b.ID
+	})
+	return messages
+}
+
+// GetConversation returns the messages exchanged with other, oldest first
+func (s *Storage) GetConversation(username, other string) []tmpl.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return readMessages(username, other)
+}
+
+// GetConversations lists the conversations of the user,
+// the most recently active first
+func (s *Storage) GetConversations(username string) []tmpl.Conversation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	others, err := os.ReadDir(filepath.Join("../storage/users", username, "messages"))
+	if err != nil {
+		return []tmpl.Conversation{}
+	}
+
+	var conversations []tmpl.Conversation
+	for _, other := range others {
+		messages := readMessages(username, other.Name())
+		if len(messages) == 0 {
+			continue
+		}
+		last := messages[len(messages)-1]
+		conversation := tmpl.Conversation{
+			With:         other.Name(),
+			LastMessage:  last.Text,
+			LastFrom:     last.From,
+			LastActivity: last.CreationDate,
+		}
+		for _, message := range messages {
+			if message.Unread {
+				conversation.Unread++
+			}
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	slices.SortStableFunc(conversations, func(a, b tmpl.Conversation) int {
+		return strings.Compare(b.LastActivity, a.LastActivity)
+	})
+	return conversations
+}
+
+// MarkConversationRead marks all messages from other as read
+func (s *Storage) MarkConversationRead(username, other string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, message := range readMessages(username, other) {
+		if !message.Unread {
+			continue
+		}
+		readMarker := filepath.Join(conversationDir(username, other), strconv.Itoa(message.ID), "read")
+		if err := writeFiles(map[string]string{readMarker: ""}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnreadMessages counts the messages without `read` marker
+// of all conversations, their files aren't read
+func (s *Storage) UnreadMessages(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	others, err := os.ReadDir(filepath.Join("../storage/users", username, "messages"))
+	if err != nil {
+		return 0
+	}
+
+	unread := 0
+	for _, other := range others {
+		// Like in GetConversations, only conversations with existing users count
+		if !userExists(other.Name()) {
+			continue
+		}
+		dir := conversationDir(username, other.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if _, err := strconv.Atoi(entry.Name()); err != nil {
+				continue
+			}
+			_, err := os.Stat(filepath.Join(dir, entry.Name(), "read"))
+			if os.IsNotExist(err) {
+				unread++
+			}
+		}
+	}
+	return unread
+}
+
+// ToggleBlock blocks or unblocks messages from other and reports
+// whether other is blocked afterwards
+func (s *Storage) ToggleBlock(username, other string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if username == other || !userExists(other) {
+		return false, ErrNotFound
+	}
+	blockedDir := filepath.Join("../storage/users", username, "blocked")
+	blockFile := filepath.Join(blockedDir, other)
+
+	if isBlocked(username, other) {
+		if err := os.Remove(blockFile); err != nil {
+			return true, fmt.Errorf("failed to remove %s: %w", blockFile, err)
+		}
+		return false, nil
+	}
+
+	if err := os.MkdirAll(blockedDir, 0750); err != nil {
+		return false, fmt.Errorf("failed to create dir %s: %w", blockedDir, err)
+	}
+	if err := writeFiles(map[string]string{blockFile: ""}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Storage) IsBlocked(username, other string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return isBlocked(username, other)
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Articles of the NNTP gateway are numbered in the order the gateway
+// saw them, storage/nntp_articles lists their locations one per line
+const newsArticlesFile = "../storage/nntp_articles"
+
+// NewsArticle is a post or a comment as the NNTP gateway shows it
+type NewsArticle struct {
+	Location     string
+	Author       string
+	Title        string // Of the post, also for comments
+	Text         string
+	CreationDate time.Time
+	// The post and the comments above a comment, its parent last
+	References []string
+}
+
+// NewsArticles returns the locations of all posts and comments by article
+// number, the first is article 1. Content missing from the list, like
+// everything written before the gateway was enabled, is appended in order
+// of creation.
+func (s *Storage) NewsArticles() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var articles []string
+	if content, err := os.ReadFile(newsArticlesFile); err == nil {
+		articles = strings.Fields(string(content))
+	}
+	numbered := make(map[string]bool, len(articles))
+	for _, location := range articles {
+		numbered[location] = true
+	}
+
+	type unnumbered struct {
+		location string
+		date     time.Time
+		isPost   bool
+		id       int
+	}
+	var missing []unnumbered
+	users, err := os.ReadDir("../storage/users")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users dir: %w", err)
+	}
+	for _, user := range users {
+		for _, kind := range []string{"post", "comment"} {
+			dir := filepath.Join("../storage/users", user.Name(), kind)
+			entries, _ := os.ReadDir(dir)
+			for _, entry := range entries {
+				location := "/" + user.Name() + "/" + kind + ":" + entry.Name()
+				if numbered[location] {
+					continue
+				}
+				date, err := os.ReadFile(filepath.Join(dir, entry.Name(), "creation_date"))
+				if err != nil {
+					continue
+				}
+				id, _ := strconv.Atoi(entry.Name())
+				missing = append(missing, unnumbered{location, ParseCreationDate(string(date)), kind == "post", id})
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return articles, nil
+	}
+
+	// Dates only have minutes, posts go before the comments on them
+	slices.SortFunc(missing, func(a, b unnumbered) int {
+		if c := a.date.Compare(b.date); c != 0 {
+			return c
+		}
+		if a.isPost != b.isPost {
+			if a.isPost {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(cmp.Compare(a.id, b.id), strings.Compare(a.location, b.location))
+	})
+	for _, article := range missing {
+		articles = append(articles, article.location)
+	}
+	content := strings.Join(articles, "\n") + "\n"
+	if err := os.WriteFile(newsArticlesFile, []byte(content), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", newsArticlesFile, err)
+	}
+	return articles, nil
+}
+
+// AddNewsArticle numbers new content after the articles already numbered
+func (s *Storage) AddNewsArticle(location string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(newsArticlesFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", newsArticlesFile, err)
+	}
+	if _, err := f.WriteString(location + "\n"); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write %s: %w", newsArticlesFile, err)
+	}
+	return f.Close()
+}
+
+// GetNewsArticle reads a post or comment without its replies
+func (s *Storage) GetNewsArticle(location string) (NewsArticle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return readNewsArticle(location)
+}
+
+// Only use when `mu` is locked
+func readNewsArticle(location string) (NewsArticle, error) {
+	user, resourcePath, _, err := parseUserResourceURI(location)
+	if err != nil {
+		return NewsArticle{}, ErrNotFound
+	}
+	files, ok := readFiles(resourcePath, "text", "creation_date")
+	if !ok {
+		return NewsArticle{}, ErrNotFound
+	}
+	article := NewsArticle{
+		Location:     location,
+		Author:       user,
+		Text:         files["text"],
+		CreationDate: ParseCreationDate(files["creation_date"]),
+	}
+
+	// Walk up the location chain to the post
+	post := location
+	for strings.Contains(post, "/comment:") && len(article.References) < 1000 {
+		_, commentPath, _, err := parseUserResourceURI(post)
+		if err != nil {
+			return NewsArticle{}, ErrNotFound
+		}
+		parent, err := os.ReadFile(filepath.Join(commentPath, "location"))
+		if err != nil {
+			return NewsArticle{}, ErrNotFound
+		}
+		post = strings.TrimSpace(string(parent))
+		article.References = append(article.References, post)
+	}
+	slices.Reverse(article.References)
+
+	_, postPath, _, err := parseUserResourceURI(post)
+	if err != nil {
+		return NewsArticle{}, ErrNotFound
+	}
+	title, err := os.ReadFile(filepath.Join(postPath, "title"))
+	if err != nil {
+		return NewsArticle{}, ErrNotFound
+	}
+	article.Title = string(title)
+	return article, nil
+}
@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"fmt"
+	"forumapp/events"
+	"forumapp/markup"
+	"forumapp/tmpl"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NotificationMention = "mention"
+	NotificationComment = "comment" // Comment on your post
+	NotificationReply   = "reply"   // Reply to your comment
+)
+
+func userExists(username string) bool {
+	if username == "" || strings.ContainsAny(username, "/\\") || username == "." || username == ".." {
+		return false
+	}
+	info, err := os.Stat(filepath.Join("../storage/users", username))
+	return err == nil && info.IsDir()
+}
+
+// Only use when `mu` is locked
+func (s *Storage) addNotification(username, kind, from, link string) error {
+	if !userExists(username) || username == from {
+		return nil
+	}
+	notificationsDir := filepath.Join("../storage/users", username, "notifications")
+	if err := os.MkdirAll(notificationsDir, 0750); err != nil {
+		return fmt.Errorf("failed to create dir %s: %w", notificationsDir, err)
+	}
+
+	notificationDir, _, err := getNextName(notificationsDir)
+	if err != nil {
+		return fmt.Errorf("failed to get next notification id: %w", err)
+	}
+
+	return createPaths(
+		[]string{notificationDir},
+		map[string]string{
+			filepath.Join(notificationDir, "type"):          kind,
+			filepath.Join(notificationDir, "from"):          from,
+			filepath.Join(notificationDir, "link"):          link,
+			filepath.Join(notificationDir, "creation_date"): time.Now().Format(creationDateLayout),
+		},
+	)
+}
+
+// notifyMentions notifies users mentioned in text, except the one
+// that already got a more specific notification
+//
+// Only use when `mu` is locked
+func (s *Storage) notifyMentions(author, text, link, except string) {
+	for _, username := range markup.Mentions(text) {
+		if username == except {
+			continue
+		}
+		if err := s.addNotification(username, NotificationMention, author, link); err != nil {
+			log.Println("Failed to add mention notification:", err)
+		}
+	}
+}
+
+// notifyComment notifies the author of the post or comment at location
+// about a new comment, and the users mentioned in it
+//
+// Only use when `mu` is locked
+func (s *Storage) notifyComment(author, text, location, link string) {
+	parentAuthor, _, _, err := parseUserResourceURI(location)
+	if err != nil {
+		log.Println("Failed to parse comment location:", err)
+		return
+	}
+
+	kind := NotificationReply
+	if strings.Contains(location, "/post:") {
+		kind = NotificationComment
+	}
+	if err := s.addNotification(parentAuthor, kind, author, link); err != nil {
+		log.Println("Failed to add comment notification:", err)
+	}
+	s.notifyMentions(author, text, link, parentAuthor)
+}
+
+func (s *Storage) notifyPost(e events.PostCreated) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.notifyMentions(e.Author, e.Text, e.Link, "")
+	return nil
+}
+
+func (s *Storage) notifyNewComment(e events.CommentCreated) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.notifyComment(e.Author, e.Text, e.Parent, e.Link)
+	return nil
+}
+
+func (s *Storage) notifyLinkedComment(e events.CommentLinked) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, _, id, err := parseUserResourceURI(e.Location)
+	if err != nil {
+		return err
+	}
+	commentID, err := strconv.Atoi(id)
+	if err != nil {
+		return err
+	}
+	s.notifyWatchers(e.Author, e.Parent, e.Post, commentID)
+	return nil
+}
+
+func readNotification(notificationsDir, id string) (tmpl.Notification, bool) {
+	dir := filepath.Join(notificationsDir, id)
+	files, ok := readFiles(dir, "type", "from", "link", "creation_date")
+	if !ok {
+		return tmpl.Notification{}, false
+	}
+	_, err := os.Stat(filepath.Join(dir, "read"))
+	return tmpl.Notification{
+		ID:           id,
+		Kind:         files["type"],
+		From:         files["from"],
+		Link:         files["link"],
+		CreationDate: files["creation_date"],
+		Unread:       os.IsNotExist(err),
+	}, true
+}
+
+// GetNotifications returns notifications of the user, newest first
+func (s *Storage) GetNotifications(username string) []tmpl.Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notificationsDir := filepath.Join("../storage/users", username, "notifications")
+	entries, err := os.ReadDir(notificationsDir)
+	if err != nil {
+		return []tmpl.Notification{}
+	}
+
+	var notifications []tmpl.Notification
+	for _, entry := range entries {
+		notification, ok := readNotification(notificationsDir, entry.Name())
+		if !ok {
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+
+	slices.SortFunc(notifications, func(a, b tmpl.Notification) int {
+		idA, _ := strconv.Atoi(a.ID)
+		idB, _ := strconv.Atoi(b.ID)
+		return idB - idA
+	})
+	return notifications
+}
+
+func (s *Storage) UnreadNotifications(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notificationsDir := filepath.Join("../storage/users", username, "notifications")
+	entries, err := os.ReadDir(notificationsDir)
+	if err != nil {
+		return 0
+	}
+
+	unread := 0
+	for _, entry := range entries {
+		_, err := os.Stat(filepath.Join(notificationsDir, entry.Name(), "read"))
+		if os.IsNotExist(err) {
+			unread++
+		}
+	}
+	return unread
+}
+
+// MarkNotificationRead marks a single notification as read and returns it
+func (s *Storage) MarkNotificationRead(username, id string) (tmpl.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := strconv.Atoi(id); err != nil {
+		return tmpl.Notification{}, ErrInvalidURI
+	}
+	notificationsDir := filepath.Join("../storage/users", username, "notifications")
+	notification, ok := readNotification(notificationsDir, id)
+	if !ok {
+		return tmpl.Notification{}, ErrNotFound
+	}
+	if notification.Unread {
+		readMarker := filepath.Join(notificationsDir, id, "read")
+		if err := writeFiles(map[string]string{readMarker: ""}); err != nil {
+			return tmpl.Notification{}, err
+		}
+		notification.Unread = false
+	}
+	return notification, nil
+}
+
+// MarkNotificationsRead marks all notifications of the user as read
+func (s *Storage) MarkNotificationsRead(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notificationsDir := filepath.Join("../storage/users", username, "notifications")
+	entries, err := os.ReadDir(notificationsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read notifications: %w", err)
+	}
+
+	for _, entry := range entries {
+		readMarker := filepath.Join(notificationsDir, entry.Name(), "read")
+		if _, err := os.Stat(readMarker); !os.IsNotExist(err) {
+			continue
+		}
+		if err := writeFiles(map[string]string{readMarker: ""}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
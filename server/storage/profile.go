@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"forumapp/tmpl"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/image/draw"
+)
+
+// Profile fields are stored in the user dir as display_name, bio,
+// website and avatar.png, the join date in creation_date
+const (
+	AvatarSize      = 128
+	maxDisplayName  = 64
+	maxBioLength    = 4000
+	maxWebsite      = 200
+	maxAvatarPixels = 4096 * 4096
+)
+
+func (s *Storage) GetProfile(username string) (tmpl.Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !userExists(username) {
+		return tmpl.Profile{}, ErrNotFound
+	}
+	userdir := filepath.Join("../storage/users", username)
+	read := func(name string) string {
+		content, _ := os.ReadFile(filepath.Join(userdir, name))
+		return strings.TrimSpace(string(content))
+	}
+
+	profile := tmpl.Profile{
+		DisplayName: read("display_name"),
+		Bio:         read("bio"),
+		Website:     read("website"),
+		JoinDate:    read("creation_date"),
+	}
+	// Accounts created before join dates were recorded
+	if profile.JoinDate == "" {
+		if info, err := os.Stat(userdir); err == nil {
+			profile.JoinDate = info.ModTime().Format(creationDateLayout)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(userdir, "avatar.png")); err == nil {
+		profile.HasAvatar = true
+	}
+
+	posts, _ := os.ReadDir(filepath.Join(userdir, "post"))
+	comments, _ := os.ReadDir(filepath.Join(userdir, "comment"))
+	profile.PostCount = len(posts)
+	profile.CommentCount = len(comments)
+	profile.Karma = readKarma(username)
+
+	return profile, nil
+}
+
+func (s *Storage) UpdateProfile(username, displayName, bio, website string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !userExists(username) {
+		return ErrNotFound
+	}
+
+	displayName = strings.TrimSpace(displayName)
+	website = strings.TrimSpace(website)
+	if utf8.RuneCountInString(displayName) > maxDisplayName ||
+		utf8.RuneCountInString(bio) > maxBioLength ||
+		len(website) > maxWebsite {
+		return ErrInvalidProfile
+	}
+	if website != "" {
+		u, err := url.Parse(website)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidProfile
+		}
+	}
+
+	userdir := filepath.Join("../storage/users", username)
+	return writeFiles(map[string]string{
+		filepath.Join(userdir, "display_name"): displayName,
+		filepath.Join(userdir, "bio"):          bio,
+		filepath.Join(userdir, "website"):      website,
+	})
+}
+
+// SetAvatar decodes a JPEG, PNG or GIF image, crops it to a square
+// and stores it scaled down to AvatarSize as PNG
+func (s *Storage) SetAvatar(username string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read avatar: %w", err)
+	}
+	// Check the size before decoding to avoid huge allocations
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width*config.Height > maxAvatarPixels {
+		return ErrInvalidAvatar
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return ErrInvalidAvatar
+	}
+
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(
+		bounds.Min.X+(bounds.Dx()-side)/2,
+		bounds.Min.Y+(bounds.Dy()-side)/2,
+	))
+	size := min(side, AvatarSize)
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, dst); err != nil {
+		return fmt.Errorf("failed to encode avatar: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !userExists(username) {
+		return ErrNotFound
+	}
+	return writeFiles(map[string]string{
+		filepath.Join("../storage/users", username, "avatar.png"): out.String(),
+	})
+}
+
+// AvatarPath returns the path of the avatar image of the user
+func (s *Storage) AvatarPath(username string) (string, error) {
+	if !userExists(username) {
+		return "", ErrNotFound
+	}
+	p := filepath.Join("../storage/users", username, "avatar.png")
+	if _, err := os.Stat(p); err != nil {
+		return "", ErrNotFound
+	}
+	return p, nil
+}
+
+// GetUserComments returns up to count comments of the user, newest first
+func (s *Storage) GetUserComments(username string, count int) []tmpl.UserComment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	commentsDir := filepath.Join("../storage/users", username, "comment")
+	entries, err := os.ReadDir(commentsDir)
+	if err != nil {
+		return []tmpl.UserComment{}
+	}
+
+	slices.SortFunc(entries, func(a, b os.DirEntry) int {
+		idA, _ := strconv.Atoi(a.Name())
+		idB, _ := strconv.Atoi(b.Name())
+		return idB - idA
+	})
+
+	comments := []tmpl.UserComment{}
+	for _, entry := range entries {
+		if len(comments) >= count {
+			break
+		}
+		files, ok := readFiles(filepath.Join(commentsDir, entry.Name()), "text", "creation_date", "location")
+		if !ok {
+			continue
+		}
+		post, err := rootPost(strings.TrimSpace(files["location"]))
+		if err != nil {
+			continue
+		}
+		_, postPath, _, err := parseUserResourceURI(post)
+		if err != nil {
+			continue
+		}
+		title, err := os.ReadFile(filepath.Join(postPath, "title"))
+		if err != nil {
+			continue
+		}
+		comments = append(comments, tmpl.UserComment{
+			Text:         files["text"],
+			CreationDate: files["creation_date"],
+			PostTitle:    string(title),
+			Link:         "/u" + post + "#" + commentAnchor(username, entry.Name()),
+		})
+	}
+	return comments
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"fmt"
+	"forumapp/events"
+	"forumapp/search"
+	"forumapp/tmpl"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const creationDateLayout = "2006-01-02 15:04"
+
+// Anchor of a comment on the post page, see comment.template
+func commentAnchor(user, id string) string {
+	return "comment-" + url.PathEscape(user) + "-" + id
+}
+
+// Anchor returns the anchor of the comment at location, empty for posts
+func Anchor(location string) string {
+	user, _, id, err := parseUserResourceURI(location)
+	if err != nil || !strings.Contains(location, "/comment:") {
+		return ""
+	}
+	return commentAnchor(user, id)
+}
+
+// ParseCreationDate parses a stored creation date, zero if it's invalid
+func ParseCreationDate(date string) time.Time {
+	t, err := time.Parse(creationDateLayout, strings.TrimSpace(date))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// rootPost follows the location chain of a comment up to its post
+func rootPost(location string) (string, error) {
+	for range 1000 {
+		_, resourcePath, _, err := parseUserResourceURI(location)
+		if err != nil {
+			return "", err
+		}
+		if strings.Contains(location, "/post:") {
+			return location, nil
+		}
+		parent, err := os.ReadFile(filepath.Join(resourcePath, "location"))
+		if err != nil {
+			return "", fmt.Errorf("failed to read comment location: %w", err)
+		}
+		location = strings.TrimSpace(string(parent))
+	}
+	return "", ErrInvalidURI
+}
+
+// RootPost returns the location of the post a comment belongs to
+func (s *Storage) RootPost(location string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return rootPost(location)
+}
+
+func postDocument(user, id, title, text, creationDate string) search.Document {
+	location := "/" + user + "/post:" + id
+	return search.Document{
+		ID:     location,
+		Kind:   "post",
+		Author: user,
+		Title:  title,
+		Text:   text,
+		Date:   ParseCreationDate(creationDate),
+		Link:   "/u" + location,
+	}
+}
+
+func commentDocument(user, id, text, creationDate, location string) (search.Document, error) {
+	post, err := rootPost(location)
+	if err != nil {
+		return search.Document{}, err
+	}
+	_, postPath, _, err := parseUserResourceURI(post)
+	if err != nil {
+		return search.Document{}, err
+	}
+	title, err := os.ReadFile(filepath.Join(postPath, "title"))
+	if err != nil {
+		return search.Document{}, fmt.Errorf("failed to get post title: %w", err)
+	}
+
+	return search.Document{
+		ID:     "/" + user + "/comment:" + id,
+		Kind:   "comment",
+		Author: user,
+		Title:  string(title),
+		Text:   text,
+		Date:   ParseCreationDate(creationDate),
+		Link:   "/u" + post + "#" + commentAnchor(user, id),
+	}, nil
+}
+
+// searchIndex returns the current index, RebuildIndex replaces it
+func (s *Storage) searchIndex() *search.Index {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.index
+}
+
+func (s *Storage) indexPost(e events.PostCreated) error {
+	_, _, id, err := parseUserResourceURI(e.Location)
+	if err != nil {
+		return err
+	}
+	s.searchIndex().Add(postDocument(e.Author, id, e.Title, e.Text, e.CreationDate))
+	return nil
+}
+
+func (s *Storage) indexComment(e events.CommentCreated) error {
+	if e.Post == "" {
+		return fmt.Errorf("comment %s isn't indexed, its post wasn't found", e.Location)
+	}
+	s.searchIndex().Add(search.Document{
+		ID:     e.Location,
+		Kind:   "comment",
+		Author: e.Author,
+		Title:  e.PostTitle,
+		Text:   e.Text,
+		Date:   ParseCreationDate(e.CreationDate),
+		Link:   e.Link,
+	})
+	return nil
+}
+
+// RebuildIndex recreates the search index from the storage tree
+func (s *Storage) RebuildIndex() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := search.NewIndex()
+	users, err := os.ReadDir("../storage/users")
+	if err != nil {
+		return fmt.Errorf("failed to read users dir: %w", err)
+	}
+
+	for _, user := range users {
+		if !user.IsDir() {
+			continue
+		}
+		userdir := filepath.Join("../storage/users", user.Name())
+
+		posts, _ := os.ReadDir(filepath.Join(userdir, "post"))
+		for _, post := range posts {
+			files, ok := readFiles(filepath.Join(userdir, "post", post.Name()),
+				"title", "text", "creation_date")
+			if !ok {
+				continue
+			}
+			index.Add(postDocument(user.Name(), post.Name(),
+				files["title"], files["text"], files["creation_date"]))
+		}
+
+		comments, _ := os.ReadDir(filepath.Join(userdir, "comment"))
+		for _, comment := range comments {
+			files, ok := readFiles(filepath.Join(userdir, "comment", comment.Name()),
+				"text", "creation_date", "location")
+			if !ok {
+				continue
+			}
+			doc, err := commentDocument(user.Name(), comment.Name(),
+				files["text"], files["creation_date"], strings.TrimSpace(files["location"]))
+			if err != nil {
+				continue
+			}
+			index.Add(doc)
+		}
+	}
+
+	s.index = index
+	return nil
+}
+
+func (s *Storage) Search(query string, limit int) []tmpl.SearchResult {
+	q := search.ParseQuery(query)
+	if q.IsEmpty() {
+		return []tmpl.SearchResult{}
+	}
+
+	var results []tmpl.SearchResult
+	for _, r := range s.searchIndex().Search(q, limit) {
+		results = append(results, tmpl.SearchResult{
+			Kind:         r.Kind,
+			Title:        r.Title,
+			Author:       r.Author,
+			CreationDate: r.Date.Format(creationDateLayout),
+			Link:         r.Link,
+			Snippet:      r.Snippet,
+		})
+	}
+	return results
+}
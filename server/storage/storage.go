@@ -3,9 +3,11 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"forumapp/events"
+	"forumapp/search"
 	"forumapp/tmpl"
 	"log"
-	"net/url"
+	"net/mail"
 	"os"
 	"path/filepath"
 	"slices"
@@ -18,18 +20,61 @@ import (
 )
 
 type Storage struct {
-	mu sync.Mutex
+	mu    sync.Mutex
+	index *search.Index
+	bus   *events.Bus
+	// Events of the locked operation, published by unlock
+	pending []func()
 }
 
-func NewStorage() (*Storage, error) {
+func NewStorage(bus *events.Bus) (*Storage, error) {
 	p := "../storage/users"
-	if _, err := os.Stat(p); !os.IsNotExist(err) {
-		return &Storage{}, nil
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		if err := os.Mkdir(p, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create dir %s: %w", p, err)
+		}
+	}
+
+	s := &Storage{index: search.NewIndex(), bus: bus}
+	s.subscribe()
+	if err := s.RebuildIndex(); err != nil {
+		return nil, fmt.Errorf("failed to build search index: %w", err)
 	}
-	if err := os.Mkdir(p, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create dir %s: %w", p, err)
+	if repaired, err := s.RepairVoteCaches(); err != nil {
+		log.Println("Failed to check vote caches:", err)
+	} else if repaired > 0 {
+		log.Println("Repaired vote caches:", repaired)
+	}
+	return s, nil
+}
+
+// subscribe keeps the search index and notifications up to date
+func (s *Storage) subscribe() {
+	events.Subscribe(s.bus, s.indexPost)
+	events.Subscribe(s.bus, s.indexComment)
+	events.Subscribe(s.bus, s.notifyPost)
+	events.Subscribe(s.bus, s.notifyNewComment)
+	events.Subscribe(s.bus, s.notifyLinkedComment)
+}
+
+// emit queues event to be published once `mu` is unlocked,
+// so subscribers can use the storage
+//
+// Only use when `mu` is locked
+func emit[E any](s *Storage, event E) {
+	s.pending = append(s.pending, func() { events.Publish(s.bus, event) })
+}
+
+// unlock unlocks `mu` and publishes the events of the operation,
+// use it instead of mu.Unlock in methods that emit events
+func (s *Storage) unlock() {
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	for _, publish := range pending {
+		publish()
 	}
-	return &Storage{}, nil
 }
 
 var (
@@ -39,14 +84,38 @@ var (
 
 	ErrInvalidURI              = errors.New("invalid URI")
 	ErrUnsupportedResourceType = errors.New("unsupported type")
+	ErrNotFound                = errors.New("not found")
+	ErrInvalidDigest           = errors.New("invalid digest frequency")
+	ErrEmptyMessage            = errors.New("message is empty")
+	ErrBlocked                 = errors.New("user is blocked")
+	ErrInvalidProfile          = errors.New("invalid profile data")
+	ErrInvalidAvatar           = errors.New("invalid avatar image")
+	ErrInvalidVote             = errors.New("invalid vote")
+	ErrInvalidToken            = errors.New("invalid token name or scopes")
+	ErrInvalidWebhook          = errors.New("invalid webhook URL or events")
+	ErrInvalidHandle           = errors.New("invalid handle, expected user@host")
+	ErrInvalidTags             = errors.New("invalid tags")
 )
 
+// SanitizeUsername returns the name a user is stored under
+func SanitizeUsername(username string) string {
+	return strings.TrimSpace(strings.ReplaceAll(username, "/", "∕"))
+}
+
+// validEmail reports whether email is a bare address like alice@example.com,
+// it ends up in the headers of digest and mailing list emails
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Name == "" && addr.Address == email
+}
+
 func (s *Storage) AddUser(email, username, pass string) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.unlock()
 
-	username = strings.TrimSpace(strings.ReplaceAll(username, "/", "∕"))
-	if email == "" || pass == "" || username == "" || !strings.Contains(email, "@") {
+	username = SanitizeUsername(username)
+	// Names with @ are reserved for users of other servers
+	if !validEmail(email) || pass == "" || username == "" || strings.Contains(username, "@") {
 		return ErrInvalidUserData
 	}
 
@@ -57,17 +126,24 @@ func (s *Storage) AddUser(email, username, pass string) error {
 
 	hashed, _ := bcrypt.GenerateFromPassword([]byte(pass), 8)
 
-	return createPaths(
+	err := createPaths(
 		[]string{
 			userdir,
 			filepath.Join(userdir, "comment"),
 			filepath.Join(userdir, "post"),
 		},
 		map[string]string{
-			filepath.Join(userdir, "email"): email,
-			filepath.Join(userdir, "pass"):  string(hashed),
+			filepath.Join(userdir, "email"):         email,
+			filepath.Join(userdir, "pass"):          string(hashed),
+			filepath.Join(userdir, "creation_date"): time.Now().Format(creationDateLayout),
 		},
 	)
+	if err != nil {
+		return err
+	}
+
+	emit(s, events.UserRegistered{Username: username})
+	return nil
 }
 
 type ResourceType uint
@@ -117,7 +193,7 @@ func parseUserResourceURI(path string) (
 	err error,
 ) {
 	urlparts := strings.Split(path, "/")
-	if len(urlparts) < 2 {
+	if len(urlparts) < 3 {
 		err = ErrInvalidURI
 		return
 	}
@@ -127,6 +203,10 @@ func parseUserResourceURI(path string) (
 		return
 	}
 	user = urlparts[1]
+	if user == "" || user == "." || user == ".." {
+		err = ErrInvalidURI
+		return
+	}
 	resourceID = resourceURI[1]
 	resourceType := resourceURI[0]
 
@@ -136,25 +216,26 @@ func parseUserResourceURI(path string) (
 		return
 	}
 
-	resourcePath, err = url.JoinPath(user, resourceType, resourceID)
-	resourcePath = "../storage/users/" + resourcePath
+	// Not url.JoinPath, usernames of remote users have a host:port
+	resourcePath = filepath.Join("../storage/users", user, resourceType, resourceID)
 	return
 }
 
-func (s *Storage) AddPost(username, postName, text string) error {
+func (s *Storage) AddPost(username, postName, text string, tags ...string) (int, error) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.unlock()
 
 	userdir := filepath.Join("../storage/users/", username)
 	if _, err := os.Stat(userdir); os.IsNotExist(err) {
-		return fmt.Errorf("logged in as non existing user: %w", err)
+		return 0, fmt.Errorf("logged in as non existing user: %w", err)
 	}
 
 	postDir, id, err := getNextName(filepath.Join(userdir, "post"))
 	if err != nil {
-		return fmt.Errorf("failed to get next post id: %w", err)
+		return 0, fmt.Errorf("failed to get next post id: %w", err)
 	}
 
+	creationDate := time.Now().Format(creationDateLayout)
 	err = createPaths(
 		[]string{
 			postDir,
@@ -164,17 +245,30 @@ func (s *Storage) AddPost(username, postName, text string) error {
 		map[string]string{
 			filepath.Join(postDir, "text"):          text,
 			filepath.Join(postDir, "title"):         postName,
-			filepath.Join(postDir, "creation_date"): time.Now().Format("2006-01-02 15:04"),
+			filepath.Join(postDir, "creation_date"): creationDate,
 			filepath.Join(postDir, "vote_cache"):    "0",
 		},
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create post paths: %w", err)
+		return 0, fmt.Errorf("failed to create post paths: %w", err)
 	}
 
-	_ = s.updateRecents("/" + username + "/post:" + strconv.Itoa(id))
+	location := "/" + username + "/post:" + strconv.Itoa(id)
+	if err := tagPost(location, postDir, tags); err != nil {
+		return 0, fmt.Errorf("failed to tag post: %w", err)
+	}
+	_ = s.updateRecents(location)
 
-	return nil
+	emit(s, events.PostCreated{
+		Location:     location,
+		Author:       username,
+		Title:        postName,
+		Text:         text,
+		CreationDate: creationDate,
+		Link:         "/u" + location,
+	})
+
+	return id, nil
 }
 
 func (s *Storage) CheckVote(username, location string) (string, error) {
@@ -185,6 +279,9 @@ func (s *Storage) CheckVote(username, location string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to parse vote location: %w", err)
 	}
+	if _, err := os.Stat(resourcePath); err != nil {
+		return "", ErrNotFound
+	}
 	votePath := filepath.Join(resourcePath, "votes")
 
 	if _, err := os.Stat(votePath); os.IsNotExist(err) {
@@ -205,28 +302,103 @@ func (s *Storage) CheckVote(username, location string) (string, error) {
 	return string(vote_type), nil
 }
 
-func (s *Storage) AddVote(username, vote_type, location string) error {
+// ToggleVote records the vote of the user on a post or comment and updates
+// its vote cache and the karma of its author in one step.
+// Vote "+" upvotes, "-" downvotes and "0" retracts the vote, voting the
+// same way again retracts it as well.
+// Returns the new score of the resource and the vote of the user.
+func (s *Storage) ToggleVote(username, vote, location string) (int, string, error) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.unlock()
 
-	_, resourcePath, _, err := parseUserResourceURI(location)
+	if vote != "+" && vote != "-" && vote != "0" {
+		return 0, "", ErrInvalidVote
+	}
+	author, resourcePath, _, err := parseUserResourceURI(location)
 	if err != nil {
-		return fmt.Errorf("failed to parse vote location: %w", err)
+		return 0, "", fmt.Errorf("failed to parse vote location: %w", err)
+	}
+	if _, err := os.Stat(resourcePath); err != nil {
+		return 0, "", ErrNotFound
 	}
 	votePath := filepath.Join(resourcePath, "votes")
+	if err := os.MkdirAll(votePath, 0750); err != nil {
+		return 0, "", fmt.Errorf("failed to create dir %s: %w", votePath, err)
+	}
+	voteFile := filepath.Join(votePath, username)
 
-	if _, err := os.Stat(votePath); os.IsNotExist(err) {
-		if err := os.Mkdir(votePath, 0750); err != nil {
-			return fmt.Errorf("failed to create dir %s: %w", votePath, err)
-		}
+	// Read the caches before the vote changes, in case they have to be computed
+	score, err := readVoteCache(location, resourcePath)
+	if err != nil {
+		return 0, "", err
+	}
+	karma := readKarma(author)
+
+	previous, err := os.ReadFile(voteFile)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, "", fmt.Errorf("failed to read vote of user '%s': %w", username, err)
+	}
+	if string(previous) == vote {
+		vote = "0"
+	}
+	delta := voteValue(vote) - voteValue(string(previous))
+	if delta == 0 {
+		return score, vote, nil
 	}
 
-	err = os.WriteFile(filepath.Join(resourcePath, "votes/", username), []byte(vote_type), 0644)
+	if vote == "0" {
+		err = os.Remove(voteFile)
+	} else {
+		err = os.WriteFile(voteFile, []byte(vote), 0644)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to update vote for user '%s': %w", username, err)
+		return 0, "", fmt.Errorf("failed to update vote for user '%s': %w", username, err)
 	}
 
-	return nil
+	score += delta
+	err = os.WriteFile(filepath.Join(resourcePath, "vote_cache"), []byte(strconv.Itoa(score)), 0644)
+	if err != nil {
+		// Put the old vote back, so votes and cache stay in sync
+		if len(previous) == 0 {
+			_ = os.Remove(voteFile)
+		} else {
+			_ = os.WriteFile(voteFile, previous, 0644)
+		}
+		return 0, "", fmt.Errorf("failed to update vote cache: %w", err)
+	}
+
+	if err := setKarma(author, karma+delta); err != nil {
+		log.Println("Failed to update karma:", err)
+	}
+
+	milestone, _ := reachedMilestone(resourcePath, score)
+	emit(s, events.VoteCast{
+		Voter:     username,
+		Location:  location,
+		Author:    author,
+		Vote:      vote,
+		Score:     score,
+		Milestone: milestone,
+		Link:      voteLink(location),
+	})
+
+	return score, vote, nil
+}
+
+// Only use when `mu` is locked
+func readVoteCache(location, resourcePath string) (int, error) {
+	voteCache, err := os.ReadFile(filepath.Join(resourcePath, "vote_cache"))
+	if err != nil {
+		voteCache, err = getVoteCacheSum(location)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get updated cache value: %w", err)
+		}
+	}
+	score, err := strconv.Atoi(strings.TrimSpace(string(voteCache)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert votes from byte to string: %w", err)
+	}
+	return score, nil
 }
 
 func getVoteCacheSum(location string) ([]byte, error) {
@@ -248,6 +420,10 @@ func getVoteCacheSum(location string) ([]byte, error) {
 			return []byte("0"), fmt.Errorf("failed to read file: %w", err)
 		}
 
+		if len(vote) == 0 {
+			log.Println("Warning: empty vote file: ", votePath+"/"+file.Name(), " - skipping")
+			continue
+		}
 		switch vote[0] {
 		case '+':
 			votesSum++
@@ -260,94 +436,176 @@ func getVoteCacheSum(location string) ([]byte, error) {
 	return []byte(strconv.Itoa(votesSum)), nil
 }
 
-func (s *Storage) UpdateVoteCache(cache_update, location string) error {
+// RepairVoteCaches recomputes the vote cache of every post and comment
+// from its vote files and fixes the ones that are out of sync.
+// Returns the number of repaired caches.
+func (s *Storage) RepairVoteCaches() (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, resourcePath, _, err := parseUserResourceURI(location)
+	users, err := os.ReadDir("../storage/users")
 	if err != nil {
-		return fmt.Errorf("failed to parse vote location: %w", err)
+		return 0, fmt.Errorf("failed to read users dir: %w", err)
 	}
 
-	vote_cache, err := os.ReadFile(filepath.Join(resourcePath, "vote_cache"))
-	if err != nil {
-		vote_cache, err = getVoteCacheSum(location)
-		if err != nil {
-			return fmt.Errorf("failed to get updated cache value: %w", err)
+	repaired := 0
+	for _, user := range users {
+		if !user.IsDir() {
+			continue
+		}
+		for _, kind := range []string{"post", "comment"} {
+			resources, _ := os.ReadDir(filepath.Join("../storage/users", user.Name(), kind))
+			for _, resource := range resources {
+				location := "/" + user.Name() + "/" + kind + ":" + resource.Name()
+				_, resourcePath, _, err := parseUserResourceURI(location)
+				if err != nil {
+					continue
+				}
+
+				sum := []byte("0")
+				if _, err := os.Stat(filepath.Join(resourcePath, "votes")); err == nil {
+					sum, err = getVoteCacheSum(location)
+					if err != nil {
+						return repaired, err
+					}
+				}
+				cached, _ := os.ReadFile(filepath.Join(resourcePath, "vote_cache"))
+				if strings.TrimSpace(string(cached)) == string(sum) {
+					continue
+				}
+
+				err = os.WriteFile(filepath.Join(resourcePath, "vote_cache"), sum, 0644)
+				if err != nil {
+					return repaired, fmt.Errorf("failed to repair vote cache of %s: %w", location, err)
+				}
+				log.Printf("Repaired vote cache of %s: %q -> %s\n", location, cached, sum)
+				repaired++
+			}
 		}
 	}
-	votes, err := strconv.Atoi(string(vote_cache))
-	if err != nil {
-		return fmt.Errorf("failed to convert votes from byte to string: %w", err)
-	}
-
-	update_amount, err := strconv.Atoi(cache_update)
-	if err != nil {
-		return fmt.Errorf("failed to convert vote cache update amount: %w", err)
-	}
-
-	votes += update_amount
-	err = os.WriteFile(filepath.Join(resourcePath, "vote_cache"), []byte(strconv.Itoa(votes)), 0644)
-	if err != nil {
-		return fmt.Errorf("failed to save upvote location: %w", err)
-	}
-
-	return nil
+	return repaired, nil
 }
 
-func (s *Storage) AddComment(username, text, location string) (int, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
+// addComment writes the comment of the user on location and returns
+// its id with the event announcing it
+//
+// Only use when `mu` is locked
+func addComment(username, text, location string) (int, events.CommentCreated, error) {
 	userdir := filepath.Join("../storage/users/", username)
 	if _, err := os.Stat(userdir); os.IsNotExist(err) {
-		return 0, fmt.Errorf("logged in as non existing user: %w", err)
+		return 0, events.CommentCreated{}, fmt.Errorf("logged in as non existing user: %w", err)
 	}
 
 	commentDir, id, err := getNextName(filepath.Join(userdir, "comment"))
 	if err != nil {
-		return 0, fmt.Errorf("failed to get next comment id: %w", err)
+		return 0, events.CommentCreated{}, fmt.Errorf("failed to get next comment id: %w", err)
 	}
 
+	creationDate := time.Now().Format(creationDateLayout)
 	err = createPaths(
 		[]string{
 			commentDir,
 			filepath.Join(commentDir, "replies"),
+			filepath.Join(commentDir, "votes"),
 		},
 		map[string]string{
 			filepath.Join(commentDir, "text"):          text,
-			filepath.Join(commentDir, "creation_date"): time.Now().Format("2006-01-02 15:04"),
+			filepath.Join(commentDir, "creation_date"): creationDate,
 			filepath.Join(commentDir, "location"):      location,
+			filepath.Join(commentDir, "vote_cache"):    "0",
 		},
 	)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create comment paths: %w", err)
+		return 0, events.CommentCreated{}, fmt.Errorf("failed to create comment paths: %w", err)
 	}
 
-	return id, nil
+	commentID := strconv.Itoa(id)
+	event := events.CommentCreated{
+		Location:     "/" + username + "/comment:" + commentID,
+		Parent:       location,
+		Author:       username,
+		Text:         text,
+		CreationDate: creationDate,
+	}
+	// The comment is written, subscribers hear of it even when its post can't be found
+	if doc, err := commentDocument(username, commentID, text, creationDate, location); err == nil {
+		event.Post, _ = rootPost(location)
+		event.PostTitle = doc.Title
+		event.Link = doc.Link
+	}
+	return id, event, nil
 }
 
-func (s *Storage) AddCommentRef(username, location, root_location, dir string, id int) error {
+// addCommentRef lists the comment in dir of location,
+// `comments` of a post or `replies` of a comment
+//
+// Only use when `mu` is locked
+func (s *Storage) addCommentRef(username, location, root_location, dir string, id int) (events.CommentLinked, error) {
 	_, resourcePath, _, err := parseUserResourceURI(location)
 	if err != nil {
-		return fmt.Errorf("failed to parse comment location: %w", err)
+		return events.CommentLinked{}, fmt.Errorf("failed to parse comment location: %w", err)
 	}
 
 	commentRef, _, err := getNextName(filepath.Join(resourcePath, dir))
 	if err != nil {
-		return fmt.Errorf("failed to get next comment reference: %w", err)
+		return events.CommentLinked{}, fmt.Errorf("failed to get next comment reference: %w", err)
 	}
 
 	refContent := fmt.Sprintf("/%s/comment:%d", username, id)
 	if err := createPaths(nil, map[string]string{
 		commentRef: refContent,
 	}); err != nil {
-		return fmt.Errorf("failed to create comment reference: %w", err)
+		return events.CommentLinked{}, fmt.Errorf("failed to create comment reference: %w", err)
 	}
 
 	_ = s.updateRecents(root_location)
+	return events.CommentLinked{
+		Location: refContent,
+		Parent:   location,
+		Post:     root_location,
+		Author:   username,
+	}, nil
+}
 
-	return nil
+// Reply adds the text as a comment of the user on parent, a post or a
+// comment, links it into the thread of its post and returns its location
+func (s *Storage) Reply(username, text, parent string) (string, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	post, err := rootPost(parent)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", parent, ErrNotFound)
+	}
+	_, parentPath, _, err := parseUserResourceURI(parent)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", parent, ErrNotFound)
+	}
+	if _, err := os.Stat(parentPath); err != nil {
+		return "", fmt.Errorf("%s: %w", parent, ErrNotFound)
+	}
+
+	// Comments on the post are listed by the post, replies by their comment
+	dir := "replies"
+	if post == parent {
+		dir = "comments"
+	}
+	id, created, err := addComment(username, text, parent)
+	if err != nil {
+		return "", err
+	}
+	linked, err := s.addCommentRef(username, parent, post, dir, id)
+	if err != nil {
+		// Don't leave a comment behind that no thread shows
+		if _, commentPath, _, err := parseUserResourceURI(created.Location); err == nil {
+			_ = os.RemoveAll(commentPath)
+		}
+		return "", err
+	}
+
+	emit(s, created)
+	emit(s, linked)
+	return created.Location, nil
 }
 
 func getNextName(basePath string) (string, int, error) {
@@ -398,12 +656,27 @@ func (s *Storage) GetPost(uri string) (tmpl.TextPost, error) {
 		Title:        string(title),
 		Text:         string(text),
 		Author:       user,
+		AuthorKarma:  readKarma(user),
 		CreationDate: string(creation_date),
+		Tags:         readTags(resourcePath),
 		Comments:     comments,
 		Votes:        string(votes),
 	}, nil
 }
 
+// GetComment returns the comment at location with its replies
+func (s *Storage) GetComment(location string) (tmpl.Comment, error) {
+	user, resourcePath, id, err := parseUserResourceURI(location)
+	if err != nil || !strings.Contains(location, "/comment:") {
+		return tmpl.Comment{}, ErrNotFound
+	}
+	comment, ok := parseComment(resourcePath, user, id)
+	if !ok {
+		return tmpl.Comment{}, ErrNotFound
+	}
+	return comment, nil
+}
+
 func parseComment(resourcePath string, user string, id string) (tmpl.Comment, bool) {
 	creation_date, err := os.ReadFile(filepath.Join(resourcePath, "creation_date"))
 	if err != nil {
@@ -421,14 +694,22 @@ func parseComment(resourcePath string, user string, id string) (tmpl.Comment, bo
 	if err != nil {
 		return tmpl.Comment{}, false
 	}
+	// Comments created before comment voting have no vote cache
+	votes, err := os.ReadFile(filepath.Join(resourcePath, "vote_cache"))
+	if err != nil {
+		votes = []byte("0")
+	}
 	userLocation := fmt.Sprintf("/%s/comment:%s", user, id)
 
 	return tmpl.Comment{
 		Author:       user,
+		AuthorKarma:  readKarma(user),
 		CreationDate: string(creation_date),
 		Location:     string(location),
 		UserLocation: userLocation,
+		Anchor:       commentAnchor(user, id),
 		Text:         string(text),
+		Votes:        string(votes),
 		Replies:      replies,
 	}, true
 }
@@ -494,6 +775,7 @@ func (s *Storage) GetRecentlyActive(count uint) []tmpl.ArticleItem {
 		articles = append(articles, tmpl.ArticleItem{
 			Title:        string(title),
 			Author:       user,
+			AuthorKarma:  readKarma(user),
 			CreationDate: string(creation_date),
 			PostLink:     "/u" + v,
 		})
@@ -510,6 +792,7 @@ func (s *Storage) GetUserArticles(username string) []tmpl.ArticleItem {
 	if err != nil {
 		return []tmpl.ArticleItem{}
 	}
+	karma := readKarma(username)
 
 	for _, v := range userPostsDir {
 		title, err := os.ReadFile(filepath.Join(userPosts, v.Name(), "title"))
@@ -523,6 +806,7 @@ func (s *Storage) GetUserArticles(username string) []tmpl.ArticleItem {
 		articles = append(articles, tmpl.ArticleItem{
 			Title:        string(title),
 			Author:       username,
+			AuthorKarma:  karma,
 			CreationDate: string(creation_date),
 			PostLink:     "/u/" + username + "/post:" + v.Name(),
 		})
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	maxTags      = 5
+	maxTagLength = 32
+)
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Posts keep their tags one per line in their `tags` file, every tag
+// lists its posts in storage/tags/<tag>/<hash of location>
+const tagsDir = "../storage/tags"
+
+// ParseTags splits comma or space separated tags,
+// lower cased and without duplicates
+func ParseTags(s string) ([]string, error) {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}) {
+		tag = strings.TrimPrefix(tag, "#")
+		if len(tag) > maxTagLength || !tagPattern.MatchString(tag) {
+			return nil, ErrInvalidTags
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxTags {
+		return nil, ErrInvalidTags
+	}
+	return tags, nil
+}
+
+// tagPost adds the post to the lists of its tags
+//
+// Only use when `mu` is locked
+func tagPost(location, postDir string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	files := map[string]string{filepath.Join(postDir, "tags"): strings.Join(tags, "\n")}
+	for _, tag := range tags {
+		dir := filepath.Join(tagsDir, tag)
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return fmt.Errorf("failed to create dir %s: %w", dir, err)
+		}
+		files[filepath.Join(dir, hashID(location))] = location
+	}
+	return writeFiles(files)
+}
+
+// readTags returns the tags of the post at postDir
+func readTags(postDir string) []string {
+	content, err := os.ReadFile(filepath.Join(postDir, "tags"))
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(content))
+}
+
+// taggedPosts returns the locations of the posts with the tag,
+// it reports false for tags no post ever had
+//
+// Only use when `mu` is locked
+func taggedPosts(tag string) ([]string, bool) {
+	if !tagPattern.MatchString(tag) {
+		return nil, false
+	}
+	entries, err := os.ReadDir(filepath.Join(tagsDir, tag))
+	if err != nil {
+		return nil, false
+	}
+	locations := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(tagsDir, tag, entry.Name()))
+		if err != nil {
+			continue
+		}
+		locations = append(locations, strings.TrimSpace(string(content)))
+	}
+	return locations, true
+}
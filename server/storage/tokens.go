@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"forumapp/tmpl"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeRead = "read"
+	ScopePost = "post"
+	ScopeVote = "vote"
+
+	// Prefix of API tokens, tells them apart from session tokens
+	TokenPrefix = "nf_"
+)
+
+var Scopes = []string{ScopeRead, ScopePost, ScopeVote}
+
+// API tokens are stored as users/<user>/tokens/<id>/{name,scopes,creation_date,last_used},
+// only the SHA-256 hash of a token is kept, in storage/tokens/<hash>
+// pointing back at <user>/<id>
+const tokensDir = "../storage/tokens"
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken creates a named API token and returns it,
+// the token itself can't be read back later
+func (s *Storage) CreateToken(username, name string, scopes []string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name = strings.TrimSpace(name)
+	if !userExists(username) {
+		return "", ErrNotFound
+	}
+	if name == "" || len(name) > 100 || len(scopes) == 0 {
+		return "", ErrInvalidToken
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return "", ErrInvalidToken
+		}
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := TokenPrefix + hex.EncodeToString(random)
+
+	userTokens := filepath.Join("../storage/users", username, "tokens")
+	for _, dir := range []string{userTokens, tokensDir} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return "", fmt.Errorf("failed to create dir %s: %w", dir, err)
+		}
+	}
+	tokenDir, id, err := getNextName(userTokens)
+	if err != nil {
+		return "", fmt.Errorf("failed to get next token id: %w", err)
+	}
+
+	hash := hashToken(token)
+	err = createPaths(
+		[]string{tokenDir},
+		map[string]string{
+			filepath.Join(tokenDir, "name"):          name,
+			filepath.Join(tokenDir, "scopes"):        strings.Join(scopes, ","),
+			filepath.Join(tokenDir, "hash"):          hash,
+			filepath.Join(tokenDir, "creation_date"): time.Now().Format(creationDateLayout),
+			filepath.Join(tokensDir, hash):           username + "/" + strconv.Itoa(id),
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token: %w", err)
+	}
+	return token, nil
+}
+
+func readToken(tokenDir, id string) (tmpl.APIToken, bool) {
+	files, ok := readFiles(filepath.Join(tokenDir, id), "name", "scopes", "creation_date")
+	if !ok {
+		return tmpl.APIToken{}, false
+	}
+	lastUsed, _ := os.ReadFile(filepath.Join(tokenDir, id, "last_used"))
+	return tmpl.APIToken{
+		ID:           id,
+		Name:         files["name"],
+		Scopes:       strings.Split(strings.TrimSpace(files["scopes"]), ","),
+		CreationDate: files["creation_date"],
+		LastUsed:     string(lastUsed),
+	}, true
+}
+
+// GetTokens lists the API tokens of the user, newest first
+func (s *Storage) GetTokens(username string) []tmpl.APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userTokens := filepath.Join("../storage/users", username, "tokens")
+	entries, err := os.ReadDir(userTokens)
+	if err != nil {
+		return []tmpl.APIToken{}
+	}
+
+	var tokens []tmpl.APIToken
+	for _, entry := range entries {
+		token, ok := readToken(userTokens, entry.Name())
+		if !ok {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	slices.SortFunc(tokens, func(a, b tmpl.APIToken) int {
+		idA, _ := strconv.Atoi(a.ID)
+		idB, _ := strconv.Atoi(b.ID)
+		return idB - idA
+	})
+	return tokens
+}
+
+func (s *Storage) RevokeToken(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := strconv.Atoi(id); err != nil {
+		return ErrNotFound
+	}
+	tokenDir := filepath.Join("../storage/users", username, "tokens", id)
+	hash, err := os.ReadFile(filepath.Join(tokenDir, "hash"))
+	if err != nil {
+		return ErrNotFound
+	}
+
+	// Remove the index entry first, so the token stops working even
+	// if removing its metadata fails
+	indexFile := filepath.Join(tokensDir, strings.TrimSpace(string(hash)))
+	if err := os.Remove(indexFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", indexFile, err)
+	}
+	if err := os.RemoveAll(tokenDir); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", tokenDir, err)
+	}
+	return nil
+}
+
+// CheckToken returns the user of an API token if the token
+// has the given scope, and records its use
+func (s *Storage) CheckToken(token, scope string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return "", false
+	}
+	ref, err := os.ReadFile(filepath.Join(tokensDir, hashToken(token)))
+	if err != nil {
+		return "", false
+	}
+	username, id, ok := strings.Cut(strings.TrimSpace(string(ref)), "/")
+	if !ok || !userExists(username) {
+		return "", false
+	}
+
+	userTokens := filepath.Join("../storage/users", username, "tokens")
+	apiToken, ok := readToken(userTokens, id)
+	if !ok || !slices.Contains(apiToken.Scopes, scope) {
+		return "", false
+	}
+
+	now := time.Now().Format(creationDateLayout)
+	if apiToken.LastUsed != now {
+		_ = writeFiles(map[string]string{filepath.Join(userTokens, id, "last_used"): now})
+	}
+	return username, true
+}
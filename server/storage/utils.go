@@ -3,6 +3,7 @@ package storage
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
 func createPaths(dirs []string, files map[string]string) error {
@@ -48,3 +49,16 @@ func writeFiles(entries map[string]string) error {
 	}
 	return nil
 }
+
+// readFiles reads the given files from dir, reports false if any is missing
+func readFiles(dir string, names ...string) (map[string]string, bool) {
+	files := make(map[string]string, len(names))
+	for _, name := range names {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, false
+		}
+		files[name] = string(content)
+	}
+	return files, true
+}
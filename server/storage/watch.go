@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"fmt"
+	"forumapp/markup"
+	"forumapp/tmpl"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const NotificationWatch = "watch" // Comment in a watched thread
+
+// Watched threads are stored as users/<user>/watching/<author>/<post id>
+// holding the comment count seen on the last visit. Every post keeps
+// the names of its watchers in its `watchers` dir.
+func watchPaths(username, location string) (watchFile, watcherFile, postPath string, err error) {
+	author, postPath, id, err := parseUserResourceURI(location)
+	if err != nil {
+		return "", "", "", err
+	}
+	if !strings.Contains(location, "/post:") {
+		return "", "", "", ErrUnsupportedResourceType
+	}
+	watchFile = filepath.Join("../storage/users", username, "watching", author, id)
+	watcherFile = filepath.Join(postPath, "watchers", username)
+	return watchFile, watcherFile, postPath, nil
+}
+
+// countComments returns the number of comments and replies below resourcePath
+func countComments(resourcePath, dir string) int {
+	refs, err := os.ReadDir(filepath.Join(resourcePath, dir))
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, ref := range refs {
+		commentURI, err := os.ReadFile(filepath.Join(resourcePath, dir, ref.Name()))
+		if err != nil {
+			continue
+		}
+		_, commentPath, _, err := parseUserResourceURI(strings.TrimSpace(string(commentURI)))
+		if err != nil {
+			continue
+		}
+		count += 1 + countComments(commentPath, "replies")
+	}
+	return count
+}
+
+// ToggleWatch starts or stops watching the post and reports
+// whether the user is watching it afterwards
+func (s *Storage) ToggleWatch(username, location string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watchFile, watcherFile, postPath, err := watchPaths(username, location)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse post location: %w", err)
+	}
+	if _, err := os.Stat(postPath); err != nil {
+		return false, ErrNotFound
+	}
+
+	if _, err := os.Stat(watchFile); err == nil {
+		if err := os.Remove(watchFile); err != nil {
+			return true, fmt.Errorf("failed to remove %s: %w", watchFile, err)
+		}
+		if err := os.Remove(watcherFile); err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("failed to remove %s: %w", watcherFile, err)
+		}
+		return false, nil
+	}
+
+	for _, dir := range []string{filepath.Dir(watchFile), filepath.Dir(watcherFile)} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return false, fmt.Errorf("failed to create dir %s: %w", dir, err)
+		}
+	}
+	count := countComments(postPath, "comments")
+	err = writeFiles(map[string]string{
+		watchFile:   strconv.Itoa(count),
+		watcherFile: "",
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Storage) IsWatching(username, location string) bool {
+	watchFile, _, _, err := watchPaths(username, location)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(watchFile)
+	return err == nil
+}
+
+// MarkThreadVisited remembers the current comment count of a watched post
+func (s *Storage) MarkThreadVisited(username, location string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watchFile, _, postPath, err := watchPaths(username, location)
+	if err != nil {
+		return fmt.Errorf("failed to parse post location: %w", err)
+	}
+	if _, err := os.Stat(watchFile); err != nil {
+		return nil
+	}
+	count := countComments(postPath, "comments")
+	return writeFiles(map[string]string{watchFile: strconv.Itoa(count)})
+}
+
+// GetWatchedThreads lists the posts watched by the user,
+// the ones with new comments first
+func (s *Storage) GetWatchedThreads(username string) []tmpl.WatchedThread {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watchingDir := filepath.Join("../storage/users", username, "watching")
+	authors, err := os.ReadDir(watchingDir)
+	if err != nil {
+		return []tmpl.WatchedThread{}
+	}
+
+	var threads []tmpl.WatchedThread
+	for _, author := range authors {
+		posts, err := os.ReadDir(filepath.Join(watchingDir, author.Name()))
+		if err != nil {
+			continue
+		}
+		for _, post := range posts {
+			seen, err := os.ReadFile(filepath.Join(watchingDir, author.Name(), post.Name()))
+			if err != nil {
+				continue
+			}
+			seenCount, _ := strconv.Atoi(strings.TrimSpace(string(seen)))
+
+			location := "/" + author.Name() + "/post:" + post.Name()
+			_, resourcePath, _, err := parseUserResourceURI(location)
+			if err != nil {
+				continue
+			}
+			files, ok := readFiles(resourcePath, "title", "creation_date")
+			if !ok {
+				continue
+			}
+			count := countComments(resourcePath, "comments")
+			threads = append(threads, tmpl.WatchedThread{
+				Title:        files["title"],
+				Author:       author.Name(),
+				CreationDate: files["creation_date"],
+				PostLink:     "/u" + location,
+				Location:     location,
+				Comments:     count,
+				NewComments:  max(0, count-seenCount),
+			})
+		}
+	}
+
+	slices.SortStableFunc(threads, func(a, b tmpl.WatchedThread) int {
+		return b.NewComments - a.NewComments
+	})
+	return threads
+}
+
+// notifyWatchers notifies everyone watching the post about a new comment,
+// skipping the users that were already notified about it directly
+//
+// Only use when `mu` is locked
+func (s *Storage) notifyWatchers(username, location, postLocation string, id int) {
+	_, postPath, _, err := parseUserResourceURI(postLocation)
+	if err != nil {
+		return
+	}
+	watchers, err := os.ReadDir(filepath.Join(postPath, "watchers"))
+	if err != nil {
+		return
+	}
+
+	notified := []string{username}
+	if parentAuthor, _, _, err := parseUserResourceURI(location); err == nil {
+		notified = append(notified, parentAuthor)
+	}
+	commentText, err := os.ReadFile(filepath.Join("../storage/users", username, "comment", strconv.Itoa(id), "text"))
+	if err == nil {
+		notified = append(notified, markup.Mentions(string(commentText))...)
+	}
+
+	link := "/u" + postLocation + "#" + commentAnchor(username, strconv.Itoa(id))
+	for _, watcher := range watchers {
+		if slices.Contains(notified, watcher.Name()) {
+			continue
+		}
+		if err := s.addNotification(watcher.Name(), NotificationWatch, username, link); err != nil {
+			log.Println("Failed to add watch notification:", err)
+		}
+	}
+}
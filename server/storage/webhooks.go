@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"forumapp/tmpl"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Events webhooks can subscribe to
+const (
+	EventPostCreated    = "post.created"
+	EventCommentCreated = "comment.created"
+	EventUserRegistered = "user.registered"
+	EventVoteMilestone  = "vote.milestone"
+)
+
+var Events = []string{EventPostCreated, EventCommentCreated, EventUserRegistered, EventVoteMilestone}
+
+// Scores that trigger EventVoteMilestone, each only once per post or comment
+var voteMilestones = []int{10, 25, 50, 100, 250, 500, 1000}
+
+// reachedMilestone reports the highest milestone score has reached
+// for the first time and remembers it
+//
+// Only use when `mu` is locked
+func reachedMilestone(resourcePath string, score int) (int, bool) {
+	milestoneFile := filepath.Join(resourcePath, "milestone")
+	previous := 0
+	if content, err := os.ReadFile(milestoneFile); err == nil {
+		previous, _ = strconv.Atoi(strings.TrimSpace(string(content)))
+	}
+
+	reached := 0
+	for _, milestone := range voteMilestones {
+		if score >= milestone && milestone > previous {
+			reached = milestone
+		}
+	}
+	if reached == 0 {
+		return 0, false
+	}
+	if err := os.WriteFile(milestoneFile, []byte(strconv.Itoa(reached)), 0644); err != nil {
+		return 0, false
+	}
+	return reached, true
+}
+
+// voteLink links to a post, or to a comment on the page of its post
+//
+// Only use when `mu` is locked
+func voteLink(location string) string {
+	user, _, id, err := parseUserResourceURI(location)
+	if err != nil || !strings.Contains(location, "/comment:") {
+		return "/u" + location
+	}
+	post, err := rootPost(location)
+	if err != nil {
+		return "/u" + location
+	}
+	return "/u" + post + "#" + commentAnchor(user, id)
+}
+
+// Admins are listed one per line in storage/admins, the file is edited by hand
+const adminsFile = "../storage/admins"
+
+func (s *Storage) IsAdmin(username string) bool {
+	if username == "" {
+		return false
+	}
+	f, err := os.Open(adminsFile)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == username {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhooks are stored as storage/webhooks/<id>/{url,secret,events,creation_date}
+// and their deliveries as storage/deliveries/<id>/..., so the log
+// outlives deleted webhooks
+const (
+	webhooksDir   = "../storage/webhooks"
+	deliveriesDir = "../storage/deliveries"
+
+	// Older deliveries are removed from the log
+	maxDeliveries = 500
+
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// AddWebhook registers a webhook with a new signing secret
+func (s *Storage) AddWebhook(rawURL string, events []string) (tmpl.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(events) == 0 {
+		return tmpl.Webhook{}, ErrInvalidWebhook
+	}
+	for _, event := range events {
+		if !slices.Contains(Events, event) {
+			return tmpl.Webhook{}, ErrInvalidWebhook
+		}
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return tmpl.Webhook{}, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+
+	if err := os.MkdirAll(webhooksDir, 0750); err != nil {
+		return tmpl.Webhook{}, fmt.Errorf("failed to create dir %s: %w", webhooksDir, err)
+	}
+	hookDir, id, err := getNextName(webhooksDir)
+	if err != nil {
+		return tmpl.Webhook{}, fmt.Errorf("failed to get next webhook id: %w", err)
+	}
+
+	hook := tmpl.Webhook{
+		ID:           strconv.Itoa(id),
+		URL:          u.String(),
+		Secret:       hex.EncodeToString(random),
+		Events:       events,
+		CreationDate: time.Now().Format(creationDateLayout),
+	}
+	err = createPaths(
+		[]string{hookDir},
+		map[string]string{
+			filepath.Join(hookDir, "url"):           hook.URL,
+			filepath.Join(hookDir, "secret"):        hook.Secret,
+			filepath.Join(hookDir, "events"):        strings.Join(events, ","),
+			filepath.Join(hookDir, "creation_date"): hook.CreationDate,
+		},
+	)
+	if err != nil {
+		return tmpl.Webhook{}, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return hook, nil
+}
+
+// GetWebhooks lists all webhooks, oldest first
+func (s *Storage) GetWebhooks() []tmpl.Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(webhooksDir)
+	if err != nil {
+		return []tmpl.Webhook{}
+	}
+
+	var hooks []tmpl.Webhook
+	for _, entry := range entries {
+		files, ok := readFiles(filepath.Join(webhooksDir, entry.Name()), "url", "secret", "events", "creation_date")
+		if !ok {
+			continue
+		}
+		hooks = append(hooks, tmpl.Webhook{
+			ID:           entry.Name(),
+			URL:          files["url"],
+			Secret:       files["secret"],
+			Events:       strings.Split(strings.TrimSpace(files["events"]), ","),
+			CreationDate: files["creation_date"],
+		})
+	}
+	slices.SortFunc(hooks, func(a, b tmpl.Webhook) int {
+		idA, _ := strconv.Atoi(a.ID)
+		idB, _ := strconv.Atoi(b.ID)
+		return idA - idB
+	})
+	return hooks
+}
+
+func (s *Storage) DeleteWebhook(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := strconv.Atoi(id); err != nil {
+		return ErrNotFound
+	}
+	hookDir := filepath.Join(webhooksDir, id)
+	if _, err := os.Stat(hookDir); err != nil {
+		return ErrNotFound
+	}
+	if err := os.RemoveAll(hookDir); err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	return nil
+}
+
+// AddDelivery logs a pending delivery of payload to the webhook
+func (s *Storage) AddDelivery(hook tmpl.Webhook, event string, payload []byte) (tmpl.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(deliveriesDir, 0750); err != nil {
+		return tmpl.WebhookDelivery{}, fmt.Errorf("failed to create dir %s: %w", deliveriesDir, err)
+	}
+	deliveryDir, id, err := getNextName(deliveriesDir)
+	if err != nil {
+		return tmpl.WebhookDelivery{}, fmt.Errorf("failed to get next delivery id: %w", err)
+	}
+
+	delivery := tmpl.WebhookDelivery{
+		ID:           strconv.Itoa(id),
+		WebhookID:    hook.ID,
+		URL:          hook.URL,
+		Event:        event,
+		Payload:      string(payload),
+		Status:       DeliveryPending,
+		CreationDate: time.Now().Format(creationDateLayout),
+	}
+	err = createPaths(
+		[]string{deliveryDir},
+		map[string]string{
+			filepath.Join(deliveryDir, "webhook"):       hook.ID,
+			filepath.Join(deliveryDir, "url"):           hook.URL,
+			filepath.Join(deliveryDir, "event"):         event,
+			filepath.Join(deliveryDir, "payload"):       string(payload),
+			filepath.Join(deliveryDir, "status"):        DeliveryPending,
+			filepath.Join(deliveryDir, "attempts"):      "0",
+			filepath.Join(deliveryDir, "creation_date"): delivery.CreationDate,
+		},
+	)
+	if err != nil {
+		return tmpl.WebhookDelivery{}, fmt.Errorf("failed to create delivery: %w", err)
+	}
+
+	// Trim the log
+	if id >= maxDeliveries {
+		_ = os.RemoveAll(filepath.Join(deliveriesDir, strconv.Itoa(id-maxDeliveries)))
+	}
+	return delivery, nil
+}
+
+// UpdateDelivery records an attempt to deliver, response is the
+// response status or the error of the attempt
+func (s *Storage) UpdateDelivery(id, status string, attempts int, response string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveryDir := filepath.Join(deliveriesDir, id)
+	if _, err := os.Stat(deliveryDir); err != nil {
+		return ErrNotFound
+	}
+	return writeFiles(map[string]string{
+		filepath.Join(deliveryDir, "status"):       status,
+		filepath.Join(deliveryDir, "attempts"):     strconv.Itoa(attempts),
+		filepath.Join(deliveryDir, "response"):     response,
+		filepath.Join(deliveryDir, "last_attempt"): time.Now().Format(creationDateLayout),
+	})
+}
+
+func readDelivery(id string) (tmpl.WebhookDelivery, bool) {
+	deliveryDir := filepath.Join(deliveriesDir, id)
+	files, ok := readFiles(deliveryDir, "webhook", "url", "event", "payload", "status", "attempts", "creation_date")
+	if !ok {
+		return tmpl.WebhookDelivery{}, false
+	}
+	attempts, _ := strconv.Atoi(strings.TrimSpace(files["attempts"]))
+	response, _ := os.ReadFile(filepath.Join(deliveryDir, "response"))
+	lastAttempt, _ := os.ReadFile(filepath.Join(deliveryDir, "last_attempt"))
+	return tmpl.WebhookDelivery{
+		ID:           id,
+		WebhookID:    files["webhook"],
+		URL:          files["url"],
+		Event:        files["event"],
+		Payload:      files["payload"],
+		Status:       files["status"],
+		Attempts:     attempts,
+		Response:     string(response),
+		LastAttempt:  string(lastAttempt),
+		CreationDate: files["creation_date"],
+	}, true
+}
+
+// GetDeliveries returns the delivery log, newest first
+func (s *Storage) GetDeliveries(count int) []tmpl.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(deliveriesDir)
+	if err != nil {
+		return []tmpl.WebhookDelivery{}
+	}
+	var ids []int
+	for _, entry := range entries {
+		if id, err := strconv.Atoi(entry.Name()); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	slices.Reverse(ids)
+
+	var deliveries []tmpl.WebhookDelivery
+	for _, id := range ids {
+		if len(deliveries) == count {
+			break
+		}
+		if delivery, ok := readDelivery(strconv.Itoa(id)); ok {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
+
+// PendingDeliveries returns the deliveries that weren't finished, oldest first
+func (s *Storage) PendingDeliveries() []tmpl.WebhookDelivery {
+	deliveries := s.GetDeliveries(maxDeliveries)
+	slices.Reverse(deliveries)
+	return slices.DeleteFunc(deliveries, func(d tmpl.WebhookDelivery) bool {
+		return d.Status != DeliveryPending
+	})
+}
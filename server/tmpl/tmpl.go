@@ -1,11 +1,16 @@
 package tmpl
 
+import "html/template"
+
 // Base for filling out page.template
 type PageBase[T any] struct {
-	PageName   string
-	Username   string
-	IsLoggedIn bool
-	Content    T
+	PageName            string
+	Username            string
+	IsLoggedIn          bool
+	IsAdmin             bool
+	UnreadNotifications int
+	UnreadMessages      int
+	Content             T
 }
 
 // Main view related templates
@@ -15,6 +20,7 @@ type (
 	ArticleItem struct {
 		Title        string
 		Author       string
+		AuthorKarma  int
 		CreationDate string
 		PostLink     string
 	}
@@ -30,21 +36,69 @@ type (
 
 // User content related templates
 type (
+	Profile struct {
+		DisplayName  string
+		Bio          string
+		Website      string
+		JoinDate     string
+		HasAvatar    bool
+		PostCount    int
+		CommentCount int
+		Karma        int
+	}
+
+	APIToken struct {
+		ID           string
+		Name         string
+		Scopes       []string
+		CreationDate string
+		LastUsed     string
+	}
+
+	// Account of another server the user follows
+	RemoteFollow struct {
+		Handle string
+		State  string
+	}
+
+	UserComment struct {
+		Text         string
+		CreationDate string
+		PostTitle    string
+		Link         string
+	}
+
 	// Matches user_page.template
 	UserPage struct {
 		Username           string
 		LogoutButtonActive bool
+		ShowMessageButton  bool
+		Digest             string
+		MailingList        bool
+		Tab                string
+		Status             string
+		Profile            Profile
 		TextPosts          []ArticleItem
+		Comments           []UserComment
+		Tokens             []APIToken
+		RemoteFollows      []RemoteFollow
+		// Shown once after the token is created
+		NewToken string
 	}
 
 	// Matches comment.template
 	Comment struct {
 		Author        string
+		AuthorKarma   int
 		CreationDate  string
 		Location      string
 		UserLocation  string
+		Anchor        string
 		Text          string
+		Votes         string
+		UserVote      string
 		ShowReplyForm bool
+		ReplyDraft    string
 		Indentation   int
 		Replies       []Comment
 	}
@@ -64,10 +118,16 @@ type (
 		Title         string
 		Text          string
 		Author        string
+		AuthorKarma   int
 		CreationDate  string
+		Tags          []string
 		TextPostError string
+		CommentDraft  string
 		Comments      []Comment
 		Votes         string
+		UserVote      string
+		Watching      bool
+		SortByScore   bool
 	}
 
 	PageType interface {
@@ -76,3 +136,139 @@ type (
 
 	UserContentPage[T PageType] = PageBase[T]
 )
+
+// Search related templates
+type (
+	SearchResult struct {
+		Kind         string
+		Title        string
+		Author       string
+		CreationDate string
+		Link         string
+		Snippet      template.HTML
+	}
+
+	// Matches search.template
+	SearchPage struct {
+		Query   string
+		Results []SearchResult
+	}
+)
+
+// Notification related templates
+type (
+	Notification struct {
+		ID           string
+		Kind         string
+		From         string
+		Link         string
+		CreationDate string
+		Unread       bool
+	}
+
+	// Matches notifications.template
+	NotificationsPage struct {
+		Notifications []Notification
+	}
+)
+
+// Watch list related templates
+type (
+	WatchedThread struct {
+		Title        string
+		Author       string
+		CreationDate string
+		PostLink     string
+		Location     string
+		Comments     int
+		NewComments  int
+	}
+
+	// Matches watching.template
+	WatchingPage struct {
+		Threads []WatchedThread
+	}
+)
+
+// Private message related templates
+type (
+	Message struct {
+		ID           int
+		From         string
+		Text         string
+		CreationDate string
+		Unread       bool
+	}
+
+	Conversation struct {
+		With         string
+		LastMessage  string
+		LastFrom     string
+		LastActivity string
+		Unread       int
+	}
+
+	// Matches messages.template
+	MessagesPage struct {
+		Conversations []Conversation
+	}
+
+	// Matches conversation.template
+	ConversationPage struct {
+		With     string
+		Blocked  bool
+		Status   string
+		Messages []Message
+	}
+)
+
+// Matches digest.template
+type Digest struct {
+	Username      string
+	Frequency     string
+	BaseURL       string
+	Notifications []Notification
+	TopPosts      []ArticleItem
+}
+
+// Matches mailing_list.template
+type MailingListComment struct {
+	Username string
+	Author   string
+	Title    string
+	Text     string
+	Link     string
+	BaseURL  string
+}
+
+// Admin related templates
+type (
+	Webhook struct {
+		ID           string
+		URL          string
+		Secret       string
+		Events       []string
+		CreationDate string
+	}
+
+	WebhookDelivery struct {
+		ID           string
+		WebhookID    string
+		URL          string
+		Event        string
+		Payload      string
+		Status       string
+		Attempts     int
+		Response     string
+		LastAttempt  string
+		CreationDate string
+	}
+
+	// Matches admin_webhooks.template
+	WebhooksPage struct {
+		Status     string
+		Events     []string
+		Webhooks   []Webhook
+		Deliveries []WebhookDelivery
+	}
+)
@@ -0,0 +1,248 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forumapp/events"
+	"forumapp/storage"
+	"forumapp/tmpl"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// Headers sent with every delivery
+	SignatureHeader = "X-Forum-Signature"
+	EventHeader     = "X-Forum-Event"
+	DeliveryHeader  = "X-Forum-Delivery"
+
+	queueSize      = 256
+	maxInFlight    = 4
+	maxAttempts    = 6
+	firstBackoff   = 5 * time.Second // Doubles after every failed attempt
+	requestTimeout = 10 * time.Second
+)
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	Event     string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	Data      Data   `json:"data"`
+}
+
+type Data struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Author string `json:"author"`
+	Title  string `json:"title,omitempty"`
+	Text   string `json:"text,omitempty"`
+	Score  int    `json:"score,omitempty"`
+}
+
+// Sign returns the value of SignatureHeader for body,
+// the hex encoded HMAC-SHA256 of the body keyed with the webhook secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers forum events to the webhooks subscribed to them,
+// retrying failed deliveries with exponential backoff
+type Dispatcher struct {
+	strg     *storage.Storage
+	client   *http.Client
+	baseURL  string
+	payloads chan Payload
+	// Limits concurrent requests
+	slots chan struct{}
+}
+
+func NewDispatcher(strg *storage.Storage, bus *events.Bus, baseURL string) *Dispatcher {
+	d := &Dispatcher{
+		strg:     strg,
+		client:   &http.Client{Timeout: requestTimeout},
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		payloads: make(chan Payload, queueSize),
+		slots:    make(chan struct{}, maxInFlight),
+	}
+	events.SubscribeAsync(bus, d.postCreated)
+	events.SubscribeAsync(bus, d.commentCreated)
+	events.SubscribeAsync(bus, d.userRegistered)
+	events.SubscribeAsync(bus, d.voteCast)
+	return d
+}
+
+func (d *Dispatcher) postCreated(e events.PostCreated) error {
+	return d.queue(storage.EventPostCreated, Data{
+		ID:     e.Location,
+		URL:    d.baseURL + e.Link,
+		Author: e.Author,
+		Title:  e.Title,
+		Text:   e.Text,
+	})
+}
+
+func (d *Dispatcher) commentCreated(e events.CommentCreated) error {
+	return d.queue(storage.EventCommentCreated, Data{
+		ID:     e.Location,
+		URL:    d.baseURL + e.Link,
+		Author: e.Author,
+		Title:  e.PostTitle,
+		Text:   e.Text,
+	})
+}
+
+func (d *Dispatcher) userRegistered(e events.UserRegistered) error {
+	return d.queue(storage.EventUserRegistered, Data{
+		ID:     e.Username,
+		URL:    d.baseURL + "/u/" + e.Username,
+		Author: e.Username,
+	})
+}
+
+func (d *Dispatcher) voteCast(e events.VoteCast) error {
+	if e.Milestone == 0 {
+		return nil
+	}
+	return d.queue(storage.EventVoteMilestone, Data{
+		ID:     e.Location,
+		URL:    d.baseURL + e.Link,
+		Author: e.Author,
+		Score:  e.Milestone,
+	})
+}
+
+func (d *Dispatcher) queue(event string, data Data) error {
+	select {
+	case d.payloads <- Payload{
+		Event:     event,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Data:      data,
+	}:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue is full, dropped %s of %s", event, data.ID)
+	}
+}
+
+// Run delivers queued events until ctx is cancelled, deliveries
+// left pending by the previous run are resumed first
+func (d *Dispatcher) Run(ctx context.Context) {
+	hooks := d.strg.GetWebhooks()
+	for _, delivery := range d.strg.PendingDeliveries() {
+		i := slices.IndexFunc(hooks, func(h tmpl.Webhook) bool { return h.ID == delivery.WebhookID })
+		if i < 0 {
+			_ = d.strg.UpdateDelivery(delivery.ID, storage.DeliveryFailed, delivery.Attempts, "webhook was deleted")
+			continue
+		}
+		go d.deliver(ctx, hooks[i].Secret, delivery)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case payload := <-d.payloads:
+			d.dispatch(ctx, payload)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, payload Payload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("Failed to encode webhook payload:", err)
+		return
+	}
+
+	for _, hook := range d.strg.GetWebhooks() {
+		if !slices.Contains(hook.Events, payload.Event) {
+			continue
+		}
+		delivery, err := d.strg.AddDelivery(hook, payload.Event, body)
+		if err != nil {
+			log.Println("Error: ", err)
+			continue
+		}
+		go d.deliver(ctx, hook.Secret, delivery)
+	}
+}
+
+// deliver posts the delivery until it succeeds or runs out of attempts,
+// a delivery interrupted by shutdown stays pending
+func (d *Dispatcher) deliver(ctx context.Context, secret string, delivery tmpl.WebhookDelivery) {
+	for attempts := delivery.Attempts; attempts < maxAttempts; {
+		if attempts > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(firstBackoff << (attempts - 1)):
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case d.slots <- struct{}{}:
+		}
+		response, err := d.post(ctx, secret, delivery)
+		<-d.slots
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+
+		attempts++
+		status := storage.DeliveryPending
+		switch {
+		case err == nil:
+			status = storage.DeliveryDelivered
+		case attempts == maxAttempts:
+			status = storage.DeliveryFailed
+		}
+		if err != nil {
+			response = err.Error()
+		}
+		if err := d.strg.UpdateDelivery(delivery.ID, status, attempts, response); err != nil {
+			log.Println("Error: ", err)
+		}
+		if status != storage.DeliveryPending {
+			return
+		}
+	}
+}
+
+// post sends one attempt and returns the response status
+func (d *Dispatcher) post(ctx context.Context, secret string, delivery tmpl.WebhookDelivery) (string, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return resp.Status, nil
+}